@@ -0,0 +1,108 @@
+package consul
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// wanTaggedAddresses are used for the targets in the remote datacenter
+const wanTaggedAddresses = "wan,wan_ipv4"
+
+// hostPort selects the host and the port to dial for the service entry.
+//
+// Host: the tagged address of the service, the tagged address of the node,
+// the service address and the node address in this order.
+// taggedAddress can be a comma separated list of keys in the order of preference.
+// Port: the 'port-meta' service meta, the port of the service tagged address
+// and the service port in this order.
+// Missing or malformed values fall through to the next source.
+func hostPort(s *api.ServiceEntry, taggedAddress, portMeta string) (string, int) {
+	var (
+		host = s.Service.Address
+		port = s.Service.Port
+	)
+	if len(host) == 0 && s.Node != nil {
+		host = s.Node.Address
+	}
+	if len(taggedAddress) > 0 {
+		host, port = taggedHostPort(s, strings.Split(taggedAddress, ","), host, port)
+	}
+	if len(portMeta) > 0 {
+		if p, err := strconv.Atoi(s.Service.Meta[portMeta]); err == nil && p > 0 && p < 1<<16 {
+			port = p
+		}
+	}
+	return host, port
+}
+
+// taggedHostPort returns the first tagged address of the service and then of the node
+// with one of the keys. host and port are returned when there is no such address.
+func taggedHostPort(s *api.ServiceEntry, keys []string, host string, port int) (string, int) {
+	for _, k := range keys {
+		if ta, ok := s.Service.TaggedAddresses[k]; ok && len(ta.Address) > 0 {
+			if ta.Port != 0 {
+				port = ta.Port
+			}
+			return ta.Address, port
+		}
+	}
+	if s.Node == nil {
+		return host, port
+	}
+	for _, k := range keys {
+		if len(s.Node.TaggedAddresses[k]) > 0 {
+			return s.Node.TaggedAddresses[k], port
+		}
+	}
+	return host, port
+}
+
+// dialAddrs returns addresses of the service entry in the 'host:port' format.
+// In the dual-stack mode the entry with both 'lan_ipv6' and 'lan_ipv4' service tagged addresses
+// has two addresses, IPv6 first. Otherwise it's the single address selected by hostPort.
+// The explicit 'tagged-address' and the WAN addresses of the remote datacenter win over the LAN pair.
+func dialAddrs(s *api.ServiceEntry, tgt *target) []string {
+	if tgt.DualStack && len(tgt.TaggedAddress) == 0 {
+		v6, ok6 := s.Service.TaggedAddresses["lan_ipv6"]
+		v4, ok4 := s.Service.TaggedAddresses["lan_ipv4"]
+		if ok6 && ok4 && len(v6.Address) > 0 && len(v4.Address) > 0 {
+			_, port6 := hostPort(s, "lan_ipv6", tgt.PortMeta)
+			_, port4 := hostPort(s, "lan_ipv4", tgt.PortMeta)
+			return []string{
+				net.JoinHostPort(v6.Address, strconv.Itoa(port6)),
+				net.JoinHostPort(v4.Address, strconv.Itoa(port4)),
+			}
+		}
+	}
+	host, port := hostPort(s, tgt.TaggedAddress, tgt.PortMeta)
+	return []string{net.JoinHostPort(host, strconv.Itoa(port))}
+}
+
+// wanAuto is the default 'wan' value to use WAN addresses only for the remote datacenter
+const wanAuto = "auto"
+
+// useWAN reports whether the target should dial WAN addresses.
+// An explicit 'tagged-address' always wins. In the 'auto' mode it compares
+// the queried datacenter with the datacenter of the local agent.
+func (t *target) useWAN(a agentSelfer) (bool, error) {
+	if len(t.TaggedAddress) > 0 {
+		return false, nil
+	}
+	switch t.WAN {
+	case "true":
+		return true, nil
+	case "", wanAuto:
+		if len(t.Dc) == 0 {
+			return false, nil
+		}
+		dc, err := localDatacenter(a)
+		if err != nil {
+			return false, err
+		}
+		return dc != t.Dc, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,180 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+)
+
+func Test_hostPort(t *testing.T) {
+	entry := &api.ServiceEntry{
+		Node: &api.Node{
+			Address:         "10.0.0.1",
+			TaggedAddresses: map[string]string{"lan": "10.0.0.1", "wan": "198.51.100.1"},
+		},
+		Service: &api.AgentService{
+			Address: "10.0.0.2",
+			Port:    8080,
+			Meta:    map[string]string{"grpc_port": "9090", "bad_port": "http"},
+			TaggedAddresses: map[string]api.ServiceAddress{
+				"lan_ipv4": {Address: "10.0.0.3", Port: 8081},
+				"virtual":  {Address: "240.0.0.1"},
+			},
+		},
+	}
+	tests := []struct {
+		name          string
+		entry         *api.ServiceEntry
+		taggedAddress string
+		portMeta      string
+		wantHost      string
+		wantPort      int
+	}{
+		{"service", entry, "", "", "10.0.0.2", 8080},
+		{"node", &api.ServiceEntry{Node: entry.Node, Service: &api.AgentService{Port: 8080}}, "", "", "10.0.0.1", 8080},
+		{"port-meta", entry, "", "grpc_port", "10.0.0.2", 9090},
+		{"port-meta-missing", entry, "", "admin_port", "10.0.0.2", 8080},
+		{"port-meta-malformed", entry, "", "bad_port", "10.0.0.2", 8080},
+		{"service-tagged", entry, "lan_ipv4", "", "10.0.0.3", 8081},
+		{"service-tagged-no-port", entry, "virtual", "", "240.0.0.1", 8080},
+		{"node-tagged", entry, "wan", "", "198.51.100.1", 8080},
+		{"tagged-missing", entry, "custom", "", "10.0.0.2", 8080},
+		{"tagged-and-port-meta", entry, "lan_ipv4", "grpc_port", "10.0.0.3", 9090},
+		{"tagged-list", entry, "wan_ipv4,wan", "", "198.51.100.1", 8080},
+		{"tagged-list-service-first", entry, "wan,virtual", "", "240.0.0.1", 8080},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := hostPort(tt.entry, tt.taggedAddress, tt.portMeta)
+			require.Equal(t, tt.wantHost, host)
+			require.Equal(t, tt.wantPort, port)
+		})
+	}
+}
+
+func Test_dialAddrs(t *testing.T) {
+	dual := &api.ServiceEntry{
+		Node: &api.Node{Address: "10.0.0.1", TaggedAddresses: map[string]string{"wan": "198.51.100.1"}},
+		Service: &api.AgentService{
+			Address: "10.0.0.2",
+			Port:    8080,
+			TaggedAddresses: map[string]api.ServiceAddress{
+				"lan_ipv4": {Address: "10.0.0.2", Port: 8080},
+				"lan_ipv6": {Address: "2001:db8::2", Port: 8080},
+			},
+		},
+	}
+	tests := []struct {
+		name  string
+		entry *api.ServiceEntry
+		tgt   target
+		want  []string
+	}{
+		{"ipv4", &api.ServiceEntry{Service: &api.AgentService{Address: "10.0.0.2", Port: 8080}}, target{}, []string{"10.0.0.2:8080"}},
+		{"ipv6", &api.ServiceEntry{Service: &api.AgentService{Address: "2001:db8::2", Port: 8080}}, target{}, []string{"[2001:db8::2]:8080"}},
+		{"ipv6-node", &api.ServiceEntry{Node: &api.Node{Address: "::1"}, Service: &api.AgentService{Port: 8080}}, target{}, []string{"[::1]:8080"}},
+		{"dual-stack-disabled", dual, target{}, []string{"10.0.0.2:8080"}},
+		{"dual-stack", dual, target{DualStack: true}, []string{"[2001:db8::2]:8080", "10.0.0.2:8080"}},
+		{"dual-stack-single", &api.ServiceEntry{Service: &api.AgentService{Address: "10.0.0.2", Port: 8080}}, target{DualStack: true}, []string{"10.0.0.2:8080"}},
+		{"dual-stack-tagged", dual, target{DualStack: true, TaggedAddress: "lan_ipv4"}, []string{"10.0.0.2:8080"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, dialAddrs(tt.entry, &tt.tgt))
+		})
+	}
+
+	t.Run("dual-stack-remote-dc", func(t *testing.T) {
+		tgt, err := parseURL("consul://127.0.0.1:8500/svc?dc=remote&dual-stack=true", target{})
+		require.NoError(t, err)
+		r := newTestResolvr(t, tgt)
+		r.agent = fakeAgent{self: map[string]map[string]interface{}{"Config": {"Datacenter": "dc1"}}}
+		r.newAgentDefaults(&tgt).apply(&tgt, time.Now())
+		require.Equal(t, []string{"198.51.100.1:8080"}, dialAddrs(dual, &tgt), "WAN address of the remote datacenter")
+	})
+}
+
+func TestClientStateDualStack(t *testing.T) {
+	r := newTestResolvr(t, target{DualStack: true})
+	state := r.clientState([]endpoint{
+		{Addr: "[2001:db8::2]:8080", AltAddrs: []string{"10.0.0.2:8080"}},
+		{Addr: "10.0.0.3:8080"},
+	})
+	require.Equal(t, []resolver.Address{
+		{Addr: "10.0.0.2:8080"},
+		{Addr: "10.0.0.3:8080"},
+		{Addr: "[2001:db8::2]:8080"},
+	}, state.Addresses)
+	require.Equal(t, []resolver.Endpoint{
+		{Addresses: []resolver.Address{{Addr: "10.0.0.3:8080"}}},
+		{Addresses: []resolver.Address{{Addr: "[2001:db8::2]:8080"}, {Addr: "10.0.0.2:8080"}}},
+	}, state.Endpoints)
+}
+
+func TestUseWAN(t *testing.T) {
+	agent := fakeAgent{self: map[string]map[string]interface{}{"Config": {"Datacenter": "dc1"}}}
+	tests := []struct {
+		name  string
+		tgt   target
+		agent fakeAgent
+		want  bool
+	}{
+		{"local-dc", target{}, agent, false},
+		{"same-dc", target{Dc: "dc1"}, agent, false},
+		{"remote-dc", target{Dc: "dc2"}, agent, true},
+		{"remote-dc-auto", target{Dc: "dc2", WAN: wanAuto}, agent, true},
+		{"remote-dc-disabled", target{Dc: "dc2", WAN: "false"}, agent, false},
+		{"remote-dc-tagged-address", target{Dc: "dc2", TaggedAddress: "lan"}, agent, false},
+		{"forced", target{WAN: "true"}, agent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.tgt.useWAN(tt.agent)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := (&target{Dc: "dc2"}).useWAN(fakeAgent{err: errors.New("agent is down")})
+	require.Error(t, err)
+}
+
+func TestWatchWANRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fconsul := &servicerMock{
+		ServiceFunc: func(_, _ string, _ bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+			if q.WaitIndex > 0 {
+				select {
+				case <-time.After(q.WaitTime):
+				case <-ctx.Done():
+				}
+			}
+			return []*api.ServiceEntry{{
+				Node:    &api.Node{Address: "10.0.0.1", TaggedAddresses: map[string]string{"wan": "198.51.100.1"}},
+				Service: &api.AgentService{Port: 80},
+			}}, &api.QueryMeta{LastIndex: 1}, nil
+		},
+	}
+	tgt, err := parseURL("consul://127.0.0.1:8500/svc?dc=remote&max-backoff=20ms", target{})
+	require.NoError(t, err)
+	r := newTestResolvr(t, tgt)
+	r.agent = &flakyAgent{failures: 1, self: map[string]map[string]interface{}{"Config": {"Datacenter": "dc1"}}}
+	out := make(chan update)
+	go r.watchService(ctx, fconsul, tgt, out)
+
+	for _, want := range []string{"10.0.0.1:80", "198.51.100.1:80"} {
+		select {
+		case u := <-out:
+			require.Equal(t, []string{want}, addrsOf(u.endpoints))
+		case <-time.After(time.Second):
+			t.Fatalf("no update with %s", want)
+		}
+	}
+}
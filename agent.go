@@ -0,0 +1,79 @@
+package consul
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// sourceAgent is the 'source' value for the instances registered on the local agent
+const sourceAgent = "agent"
+
+type agentHealther interface {
+	agentSelfer
+	AgentHealthServiceByNameOpts(string, *api.QueryOptions) (string, []api.AgentServiceChecksInfo, error)
+}
+
+// agentServicer implements servicer with the local agent only.
+// The agent health endpoint has no blocking queries so it polls.
+// Tag and passingOnly are applied locally, the datacenter is always the agent's one.
+type agentServicer struct {
+	a      agentHealther
+	poller *poller
+}
+
+func (s agentServicer) Service(service, tag string, passingOnly bool, _ *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	start := time.Now()
+	s.poller.wait(service)
+
+	_, infos, err := s.a.AgentHealthServiceByNameOpts(service, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Couldn't fetch the local agent health")
+	}
+	var node *api.Node
+	ss := make([]*api.ServiceEntry, 0, len(infos))
+	for _, info := range infos {
+		if info.Service == nil ||
+			(len(tag) > 0 && !hasTag(info.Service.Tags, tag)) ||
+			(passingOnly && info.AggregatedStatus != api.HealthPassing) {
+			continue
+		}
+		if node == nil {
+			// Services registered without address use the address of the agent's node
+			if node, err = agentNode(s.a); err != nil {
+				return nil, nil, err
+			}
+		}
+		ss = append(ss, &api.ServiceEntry{Node: node, Service: info.Service, Checks: info.Checks})
+	}
+	return ss, &api.QueryMeta{RequestTime: time.Since(start)}, nil
+}
+
+// agentNode returns the node of the local agent
+func agentNode(a agentSelfer) (*api.Node, error) {
+	self, err := a.Self()
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't fetch the local agent")
+	}
+	node := &api.Node{}
+	node.Node, _ = self["Config"]["NodeName"].(string)
+	node.Datacenter, _ = self["Config"]["Datacenter"].(string)
+	node.Address, _ = self["Member"]["Addr"].(string)
+	if meta, ok := self["Meta"]; ok {
+		node.Meta = make(map[string]string, len(meta))
+		for k, v := range meta {
+			node.Meta[k], _ = v.(string)
+		}
+	}
+	return node, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
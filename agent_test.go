@@ -0,0 +1,75 @@
+package consul
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAgentHealth struct {
+	fakeAgent
+	infos []api.AgentServiceChecksInfo
+	err   error
+}
+
+func (f fakeAgentHealth) AgentHealthServiceByNameOpts(string, *api.QueryOptions) (string, []api.AgentServiceChecksInfo, error) {
+	return api.HealthPassing, f.infos, f.err
+}
+
+func TestAgentServicer(t *testing.T) {
+	agent := fakeAgent{self: map[string]map[string]interface{}{
+		"Config": {"NodeName": "node-1", "Datacenter": "dc1"},
+		"Member": {"Addr": "10.0.0.1"},
+		"Meta":   {"zone": "a"},
+	}}
+	infos := []api.AgentServiceChecksInfo{
+		{AggregatedStatus: api.HealthPassing, Service: &api.AgentService{ID: "svc-1", Port: 8080, Tags: []string{"grpc"}}},
+		{AggregatedStatus: api.HealthCritical, Service: &api.AgentService{ID: "svc-2", Address: "10.0.0.2", Port: 8080, Tags: []string{"grpc"}},
+			Checks: api.HealthChecks{{Status: api.HealthCritical}}},
+		{AggregatedStatus: api.HealthPassing, Service: &api.AgentService{ID: "svc-3", Port: 9090}},
+	}
+	tests := []struct {
+		name        string
+		tag         string
+		passingOnly bool
+		agent       fakeAgentHealth
+		want        []endpoint
+		err         bool
+	}{
+		{"all", "", false, fakeAgentHealth{fakeAgent: agent, infos: infos},
+			[]endpoint{
+				{Addr: "10.0.0.1:8080", AltAddrs: []string{}, Node: "node-1", Datacenter: "dc1", ID: "svc-1", Tags: []string{"grpc"}, NodeMeta: map[string]string{"zone": "a"}, Status: api.HealthPassing},
+				{Addr: "10.0.0.2:8080", AltAddrs: []string{}, Node: "node-1", Datacenter: "dc1", ID: "svc-2", Tags: []string{"grpc"}, NodeMeta: map[string]string{"zone": "a"}, Status: api.HealthCritical},
+				{Addr: "10.0.0.1:9090", AltAddrs: []string{}, Node: "node-1", Datacenter: "dc1", ID: "svc-3", NodeMeta: map[string]string{"zone": "a"}, Status: api.HealthPassing},
+			},
+			false,
+		},
+		{"tag-passing", "grpc", true, fakeAgentHealth{fakeAgent: agent, infos: infos},
+			[]endpoint{
+				{Addr: "10.0.0.1:8080", AltAddrs: []string{}, Node: "node-1", Datacenter: "dc1", ID: "svc-1", Tags: []string{"grpc"}, NodeMeta: map[string]string{"zone": "a"}, Status: api.HealthPassing},
+			},
+			false,
+		},
+		{"no-instances", "", false, fakeAgentHealth{fakeAgent: agent}, []endpoint{}, false},
+		{"agent-error", "", false, fakeAgentHealth{fakeAgent: agent, err: errors.New("agent is down")}, nil, true},
+		{"self-error", "", false, fakeAgentHealth{fakeAgent: fakeAgent{err: errors.New("agent is down")}, infos: infos}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := agentServicer{a: tt.agent, poller: newPoller(time.Millisecond)}
+			ss, _, err := s.Service("svc", tt.tag, tt.passingOnly, nil)
+			require.Equal(t, tt.err, err != nil)
+			if tt.err {
+				return
+			}
+			got := make([]endpoint, 0, len(ss))
+			for _, s := range ss {
+				got = append(got, newEndpoint(s, &target{}))
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
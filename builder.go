@@ -6,6 +6,8 @@ import (
 
 	"github.com/hashicorp/consul/api"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/resolver"
 )
 
@@ -14,29 +16,100 @@ import (
 const schemeName = "consul"
 
 // builder implements resolver.Builder and use for constructing all consul resolvers
-type builder struct{}
+type builder struct {
+	scheme         string
+	consulConfig   *api.Config
+	consulClient   func(resolver.Target) (*api.Client, error)
+	defaults       target
+	filter         func(*api.ServiceEntry) bool
+	hooks          []UpdateHook
+	updateChans    []chan<- EndpointsUpdate
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	logger         Logger
+}
+
+// NewBuilder returns a resolver.Builder configured with opts.
+// It can be passed to grpc.WithResolvers for a single ClientConn or registered with resolver.Register.
+// Parameters of the target URL override the defaults set by the options.
+func NewBuilder(opts ...Option) resolver.Builder {
+	b := &builder{
+		scheme: schemeName,
+		logger: NewGrpcLogger(),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
+}
 
 func (b *builder) Build(url resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
 	dsn := strings.Join([]string{schemeName + ":/", url.URL.Host, url.URL.Path + "?" + url.URL.RawQuery}, "/")
-	tgt, err := parseURL(dsn)
+	tgt, err := parseURL(dsn, b.defaults)
 	if err != nil {
 		return nil, errors.Wrap(err, "Wrong consul URL")
 	}
-	cli, err := api.NewClient(tgt.consulConfig())
+	if (tgt.Subset > 0 || tgt.Order == orderRendezvous) && len(tgt.ClientID) == 0 {
+		tgt.ClientID = defaultClientID()
+	}
+	cli, err := b.newConsulClient(url, tgt)
 	if err != nil {
 		return nil, errors.Wrap(err, "Couldn't connect to the Consul API")
 	}
+	tel, err := newTelemetry(b.tracerProvider, b.meterProvider)
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't set up telemetry")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	pipe := make(chan []string)
-	go watchConsulService(ctx, cli.Health(), tgt, pipe)
-	go populateEndpoints(ctx, cc, pipe)
+	r := &resolvr{
+		cancelFunc:  cancel,
+		grpcTarget:  url,
+		tgt:         tgt,
+		agent:       cli.Agent(),
+		filter:      b.filter,
+		telemetry:   tel,
+		log:         leveledLogger{Logger: b.logger, min: tgt.LogLevel},
+		hooks:       b.hooks,
+		updateChans: b.updateChans,
+		state:       &resolverState{},
+	}
+	registerResolver(r)
+	var s servicer = cli.Health()
+	switch tgt.Source {
+	case sourceCatalog:
+		s = catalogServicer{c: cli.Catalog()}
+	case sourceAgent:
+		s = agentServicer{a: cli.Agent(), poller: newPoller(tgt.Wait)}
+	}
+	switch {
+	case tgt.Transport == transportDNS:
+		s = newDNSServicer(tgt)
+		r.agent = nil // the agent HTTP API is unavailable
+	case tgt.DNSFallback:
+		s = &fallbackServicer{primary: s, fallback: newDNSServicer(tgt), log: r.log}
+	}
+	pipe := make(chan update)
+	go r.watchConsulService(ctx, s, pipe)
+	go r.populateEndpoints(ctx, cc, pipe)
+
+	return r, nil
+}
 
-	return &resolvr{cancelFunc: cancel}, nil
+// newConsulClient returns the injected client or creates a new one for the target
+func (b *builder) newConsulClient(url resolver.Target, tgt target) (*api.Client, error) {
+	if b.consulClient != nil {
+		return b.consulClient(url)
+	}
+	cfg, err := tgt.consulConfig(b.consulConfig)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewClient(cfg)
 }
 
 // Scheme returns the scheme supported by this resolver.
 // Scheme is defined at https://github.com/grpc/grpc/blob/master/doc/naming.md.
 func (b *builder) Scheme() string {
-	return schemeName
+	return b.scheme
 }
@@ -0,0 +1,187 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+)
+
+// newFakeConsul starts HTTP server which answers health queries with entries
+func newFakeConsul(t *testing.T, entries []*api.ServiceEntry) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("index") != "" {
+			// blocking query: nothing has changed
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		_ = json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBuilder(t *testing.T) {
+	srv := newFakeConsul(t, []*api.ServiceEntry{
+		{Service: &api.AgentService{Address: "127.0.0.1", Port: 1024, Tags: []string{"a"}}},
+		{Service: &api.AgentService{Address: "127.0.0.2", Port: 1024, Tags: []string{"b"}}},
+	})
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	b := NewBuilder(
+		WithScheme("my-consul"),
+		WithFilter(func(e *api.ServiceEntry) bool { return e.Service.Tags[0] == "b" }),
+	)
+	require.Equal(t, "my-consul", b.Scheme())
+
+	fcc := &ClientConnMock{UpdateStateFunc: func(resolver.State) error { return nil }}
+	r, err := b.Build(resolver.Target{URL: url.URL{Scheme: "my-consul", Host: u.Host, Path: "/svc"}}, fcc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Equal(t, []resolver.Address{{Addr: "127.0.0.2:1024"}}, waitForAddresses(t, fcc, time.Second))
+}
+
+func TestConsulConfig(t *testing.T) {
+	base := &api.Config{
+		Address:    "base:8500",
+		Token:      "base-token",
+		Datacenter: "base-dc",
+		HttpClient: &http.Client{Timeout: time.Minute},
+	}
+	tests := []struct {
+		name string
+		tgt  target
+		want func(t *testing.T, cfg *api.Config)
+	}{
+		{"inherits", target{Addr: "127.0.0.1:8500"},
+			func(t *testing.T, cfg *api.Config) {
+				require.Equal(t, "127.0.0.1:8500", cfg.Address)
+				require.Equal(t, "base-token", cfg.Token)
+				require.Equal(t, "base-dc", cfg.Datacenter)
+				require.Equal(t, base.HttpClient, cfg.HttpClient)
+			},
+		},
+		{"overrides", target{Addr: "127.0.0.1:8500", Token: "url-token", Timeout: time.Second, User: "u", Password: "p"},
+			func(t *testing.T, cfg *api.Config) {
+				require.Equal(t, "url-token", cfg.Token)
+				require.Equal(t, time.Second, cfg.HttpClient.Timeout)
+				require.Equal(t, time.Minute, base.HttpClient.Timeout)
+				require.Equal(t, &api.HttpBasicAuth{Username: "u", Password: "p"}, cfg.HttpAuth)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.tgt.consulConfig(base)
+			require.NoError(t, err)
+			tt.want(t, cfg)
+		})
+	}
+}
+
+func TestConsulConfigEnv(t *testing.T) {
+	t.Setenv("CONSUL_HTTP_ADDR", "consul.example.com:8501")
+	t.Setenv("CONSUL_HTTP_SSL", "true")
+	t.Setenv("CONSUL_HTTP_TOKEN", "env-token")
+	t.Setenv("CONSUL_HTTP_AUTH", "env-user:env-password")
+	t.Setenv("CONSUL_TLS_SERVER_NAME", "consul.internal")
+
+	tgt, err := parseURL("consul:///svc?timeout=90s&insecure=true", target{})
+	require.NoError(t, err)
+	cfg, err := tgt.consulConfig(nil)
+	require.NoError(t, err)
+	require.Equal(t, "consul.example.com:8501", cfg.Address)
+	require.Equal(t, "https", cfg.Scheme)
+	require.Equal(t, "env-token", cfg.Token)
+	require.Equal(t, &api.HttpBasicAuth{Username: "env-user", Password: "env-password"}, cfg.HttpAuth)
+	require.Equal(t, "consul.internal", cfg.TLSConfig.Address)
+	require.True(t, cfg.TLSConfig.InsecureSkipVerify)
+	require.Equal(t, 90*time.Second, cfg.HttpClient.Timeout)
+	tlsCfg := cfg.HttpClient.Transport.(*http.Transport).TLSClientConfig
+	require.Equal(t, "consul.internal", tlsCfg.ServerName, "TLS settings of the environment are kept")
+	require.True(t, tlsCfg.InsecureSkipVerify)
+
+	tgt, err = parseURL("consul://127.0.0.1:8500/svc?token=url-token", target{})
+	require.NoError(t, err)
+	cfg, err = tgt.consulConfig(nil)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:8500", cfg.Address)
+	require.Equal(t, "url-token", cfg.Token)
+
+	tgt, err = parseURL("consul:///svc?token=url-token", target{})
+	require.NoError(t, err)
+	cfg, err = tgt.consulConfig(nil)
+	require.NoError(t, err)
+	require.Equal(t, "consul.example.com:8501", cfg.Address)
+	require.Equal(t, "url-token", cfg.Token, "URL parameters override the environment")
+}
+
+func TestConsulConfigBrokenTLS(t *testing.T) {
+	t.Setenv("CONSUL_HTTP_ADDR", "consul.example.com:8501")
+	t.Setenv("CONSUL_HTTP_SSL", "true")
+	t.Setenv("CONSUL_CACERT", filepath.Join(t.TempDir(), "missing-ca.pem"))
+
+	tgt, err := parseURL("consul:///svc?timeout=5s", target{})
+	require.NoError(t, err)
+	_, err = tgt.consulConfig(nil)
+	require.Error(t, err, "broken TLS settings of the environment aren't dropped")
+
+	_, err = NewBuilder().Build(resolver.Target{URL: url.URL{Scheme: schemeName, Path: "/svc", RawQuery: "timeout=5s"}},
+		&ClientConnMock{}, resolver.BuildOptions{})
+	require.Error(t, err)
+}
+
+func TestBuilderEmptyAuthority(t *testing.T) {
+	srv := newFakeConsul(t, []*api.ServiceEntry{
+		{Service: &api.AgentService{Address: "127.0.0.1", Port: 1024}},
+	})
+	t.Setenv("CONSUL_HTTP_ADDR", srv.URL)
+
+	fcc := &ClientConnMock{UpdateStateFunc: func(resolver.State) error { return nil }}
+	r, err := NewBuilder().Build(resolver.Target{URL: url.URL{Scheme: schemeName, Path: "/svc"}}, fcc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Equal(t, []resolver.Address{{Addr: "127.0.0.1:1024"}}, waitForAddresses(t, fcc, time.Second))
+}
+
+func TestBuilderConsulClientFactory(t *testing.T) {
+	srv := newFakeConsul(t, []*api.ServiceEntry{
+		{Service: &api.AgentService{Address: "127.0.0.1", Port: 1024}},
+	})
+	cfg := api.DefaultConfig()
+	cfg.Address = srv.URL
+	cli, err := api.NewClient(cfg)
+	require.NoError(t, err)
+
+	var keys []string
+	b := NewBuilder(WithConsulClientFactory(func(tgt resolver.Target) (*api.Client, error) {
+		keys = append(keys, tgt.URL.Path)
+		if tgt.URL.Path == "/broken" {
+			return nil, errors.New("no client")
+		}
+		return cli, nil
+	}))
+
+	_, err = b.Build(resolver.Target{URL: url.URL{Scheme: schemeName, Host: "unreachable", Path: "/broken"}}, &ClientConnMock{}, resolver.BuildOptions{})
+	require.Error(t, err)
+
+	fcc := &ClientConnMock{UpdateStateFunc: func(resolver.State) error { return nil }}
+	r, err := b.Build(resolver.Target{URL: url.URL{Scheme: schemeName, Host: "unreachable", Path: "/svc"}}, fcc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Equal(t, []resolver.Address{{Addr: "127.0.0.1:1024"}}, waitForAddresses(t, fcc, time.Second))
+	require.Equal(t, []string{"/broken", "/svc"}, keys)
+}
@@ -0,0 +1,51 @@
+package consul
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+// Values of the 'source' URL parameter
+const (
+	sourceHealth  = "health"
+	sourceCatalog = "catalog"
+)
+
+type cataloger interface {
+	Service(string, string, *api.QueryOptions) ([]*api.CatalogService, *api.QueryMeta, error)
+}
+
+// catalogServicer implements servicer with the Consul catalog.
+// It's for the services without health checks: all instances are passing.
+type catalogServicer struct {
+	c cataloger
+}
+
+func (c catalogServicer) Service(service, tag string, _ bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	cs, meta, err := c.c.Service(service, tag, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	ss := make([]*api.ServiceEntry, 0, len(cs))
+	for _, s := range cs {
+		ss = append(ss, &api.ServiceEntry{
+			Node: &api.Node{
+				ID:              s.ID,
+				Node:            s.Node,
+				Address:         s.Address,
+				Datacenter:      s.Datacenter,
+				TaggedAddresses: s.TaggedAddresses,
+				Meta:            s.NodeMeta,
+			},
+			Service: &api.AgentService{
+				ID:              s.ServiceID,
+				Service:         s.ServiceName,
+				Address:         s.ServiceAddress,
+				Port:            s.ServicePort,
+				Tags:            s.ServiceTags,
+				Meta:            s.ServiceMeta,
+				TaggedAddresses: s.ServiceTaggedAddresses,
+			},
+		})
+	}
+	return ss, meta, nil
+}
@@ -0,0 +1,83 @@
+package consul
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogServicer(t *testing.T) {
+	tests := []struct {
+		name     string
+		tgt      target
+		services []*api.CatalogService
+		want     []string
+	}{
+		{"service-address", target{Service: "svc", Tag: "sync"},
+			[]*api.CatalogService{
+				{Node: "node-1", Address: "10.0.0.1", ServiceAddress: "10.0.1.1", ServicePort: 8080},
+				{Node: "node-2", Address: "10.0.0.2", ServicePort: 8080},
+			},
+			[]string{"10.0.1.1:8080", "10.0.0.2:8080"},
+		},
+		{"tagged-address-and-port-meta", target{Service: "svc", TaggedAddress: "wan", PortMeta: "grpc_port"},
+			[]*api.CatalogService{
+				{
+					Address:                "10.0.0.1",
+					ServicePort:            8080,
+					ServiceMeta:            map[string]string{"grpc_port": "9090"},
+					ServiceTaggedAddresses: map[string]api.ServiceAddress{"wan": {Address: "198.51.100.1", Port: 8443}},
+				},
+				{
+					Address:         "10.0.0.2",
+					ServicePort:     8080,
+					TaggedAddresses: map[string]string{"wan": "198.51.100.2"},
+				},
+			},
+			[]string{"198.51.100.1:9090", "198.51.100.2:8080"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+
+			fcatalog := &catalogerMock{
+				ServiceFunc: func(service, tag string, q *api.QueryOptions) ([]*api.CatalogService, *api.QueryMeta, error) {
+					if q.WaitIndex > 0 {
+						<-ctx.Done()
+					}
+					return tt.services, &api.QueryMeta{LastIndex: 1}, nil
+				},
+			}
+			out := make(chan update, 1)
+			r := newTestResolvr(t, tt.tgt)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				r.watchConsulService(ctx, catalogServicer{c: fcatalog}, out)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			select {
+			case u := <-out:
+				require.Equal(t, tt.want, addrsOf(u.endpoints))
+				for _, e := range u.endpoints {
+					require.Equal(t, api.HealthPassing, e.Status)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no endpoints")
+			}
+			calls := fcatalog.ServiceCalls()
+			require.NotEmpty(t, calls)
+			require.Equal(t, tt.tgt.Service, calls[0].S1)
+			require.Equal(t, tt.tgt.Tag, calls[0].S2)
+		})
+	}
+}
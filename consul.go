@@ -2,25 +2,87 @@ package consul
 
 import (
 	"context"
-	"fmt"
 	"sort"
 	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/jpillora/backoff"
-	"google.golang.org/grpc/grpclog"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
 )
 
 // init function needs for  auto-register in resolvers registry
 func init() {
-	resolver.Register(&builder{})
+	resolver.Register(NewBuilder())
 }
 
 // resolvr implements resolver.Resolver from the gRPC package.
 // It watches for endpoints changes and pushes them to the underlying gRPC connection.
 type resolvr struct {
-	cancelFunc context.CancelFunc
+	cancelFunc  context.CancelFunc
+	grpcTarget  resolver.Target
+	tgt         target
+	agent       agentSelfer
+	filter      func(*api.ServiceEntry) bool
+	telemetry   *telemetry
+	log         Logger
+	hooks       []UpdateHook
+	updateChans []chan<- EndpointsUpdate
+	state       *resolverState
+}
+
+// update is a single batch of endpoints passed from the watcher to the client connection.
+// span belongs to the Consul lookup which produced the batch and is ended after cc.UpdateState.
+type update struct {
+	span      trace.Span
+	endpoints []endpoint
+}
+
+// endpoint is a single instance of the service resolved from Consul
+type endpoint struct {
+	Addr       string            `json:"addr"`
+	AltAddrs   []string          `json:"alt_addrs,omitempty"`
+	Node       string            `json:"node,omitempty"`
+	Datacenter string            `json:"datacenter,omitempty"`
+	ID         string            `json:"id,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	NodeMeta   map[string]string `json:"node_meta,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	// Service and Weight are set for the multi-service targets
+	Service string `json:"service,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+	// Draining is set for the departed endpoints during 'removal-grace'
+	Draining bool `json:"draining,omitempty"`
+	// Priority of the locality is set for the 'locality' targets
+	Priority int `json:"priority,omitempty"`
+}
+
+func newEndpoint(s *api.ServiceEntry, tgt *target) endpoint {
+	e := endpoint{
+		ID:     s.Service.ID,
+		Tags:   s.Service.Tags,
+		Meta:   s.Service.Meta,
+		Status: s.Checks.AggregatedStatus(),
+	}
+	if s.Node != nil {
+		e.Node = s.Node.Node
+		e.Datacenter = s.Node.Datacenter
+		e.NodeMeta = s.Node.Meta
+	}
+	addrs := dialAddrs(s, tgt)
+	e.Addr, e.AltAddrs = addrs[0], addrs[1:]
+	return e
+}
+
+// addrsOf returns the addresses of the endpoints
+func addrsOf(ee []endpoint) []string {
+	addrs := make([]string, 0, len(ee))
+	for _, e := range ee {
+		addrs = append(addrs, e.Addr)
+	}
+	return addrs
 }
 
 // ResolveNow will be skipped due unnecessary in this case
@@ -29,15 +91,32 @@ func (r *resolvr) ResolveNow(resolver.ResolveNowOptions) {}
 // Close closes the resolver.
 func (r *resolvr) Close() {
 	r.cancelFunc()
+	unregisterResolver(r)
 }
 
-//go:generate ./bin/moq -out mocks_test.go . servicer
+//go:generate ./bin/moq -out mocks_test.go . servicer cataloger
 type servicer interface {
 	Service(string, string, bool, *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
 }
 
-func watchConsulService(ctx context.Context, s servicer, tgt target, out chan<- []string) {
-	res := make(chan []string)
+// watchConsulService watches the service of the target.
+// Every service of the multi-service target is watched separately and the results are merged.
+func (r *resolvr) watchConsulService(ctx context.Context, s servicer, out chan<- update) {
+	if len(r.tgt.Services) == 0 {
+		r.watchService(ctx, s, r.tgt, out)
+		return
+	}
+	ins := make([]<-chan update, 0, len(r.tgt.Services))
+	for _, spec := range r.tgt.Services {
+		in := make(chan update)
+		go r.watchService(ctx, s, r.tgt.forService(spec), in)
+		ins = append(ins, in)
+	}
+	r.mergeServices(ctx, ins, out)
+}
+
+func (r *resolvr) watchService(ctx context.Context, s servicer, tgt target, out chan<- update) {
+	res := make(chan update)
 	quit := make(chan struct{})
 	bck := &backoff.Backoff{
 		Factor: 2,
@@ -45,23 +124,34 @@ func watchConsulService(ctx context.Context, s servicer, tgt target, out chan<-
 		Min:    10 * time.Millisecond,
 		Max:    tgt.MaxBackoff,
 	}
+	spanName := lookupSpanName(s)
 	go func() {
+		agent := r.newAgentDefaults(&tgt)
 		var lastIndex uint64
 		for {
+			waitIndex := lastIndex
+			if agent.apply(&tgt, time.Now()) {
+				// Apply the settings of the agent without waiting for a change
+				waitIndex = 0
+			}
+			retrying := agent.pending()
+			span := r.telemetry.startLookup(spanName, tgt, lastIndex)
 			ss, meta, err := s.Service(
 				tgt.Service,
 				tgt.Tag,
-				tgt.Healthy,
+				tgt.Healthy && len(tgt.Health) == 0 && !tgt.selectsChecks(),
 				&api.QueryOptions{
-					WaitIndex:         lastIndex,
+					WaitIndex:         waitIndex,
 					Near:              tgt.Near,
-					WaitTime:          tgt.Wait,
+					WaitTime:          agent.waitTime(tgt.Wait, time.Now()),
 					Datacenter:        tgt.Dc,
 					AllowStale:        tgt.AllowStale,
 					RequireConsistent: tgt.RequireConsistent,
 				},
 			)
 			if err != nil {
+				r.telemetry.endLookup(span, tgt, 0, 0, err)
+				r.state.lookupFailed(err)
 				// No need to continue if the context is done/cancelled.
 				// We check that here directly because the check for the closed quit channel
 				// at the end of the loop is not reached when calling continue here.
@@ -69,35 +159,59 @@ func watchConsulService(ctx context.Context, s servicer, tgt target, out chan<-
 				case <-quit:
 					return
 				default:
-					grpclog.Errorf("[Consul resolver] Couldn't fetch endpoints. target={%s}; error={%v}", tgt.String(), err)
-					time.Sleep(bck.Duration())
+					r.log.Log(LevelError, "Couldn't fetch endpoints", tgt.logFields("index", lastIndex, "error", err)...)
+					d := bck.Duration()
+					r.state.backingOff(d)
+					time.Sleep(d)
 					continue
 				}
 			}
 			bck.Reset()
 			lastIndex = meta.LastIndex
-			grpclog.Infof("[Consul resolver] %d endpoints fetched in(+wait) %s for target={%s}",
-				len(ss),
-				meta.RequestTime,
-				tgt.String(),
-			)
+			r.log.Log(LevelDebug, "Endpoints fetched",
+				tgt.logFields("index", lastIndex, "count", len(ss), "request_time", meta.RequestTime)...)
 
-			ee := make([]string, 0, len(ss))
+			r.state.lookupSucceeded(lastIndex)
+			if retrying && waitIndex > 0 && lastIndex == waitIndex {
+				// The wait was cut for the retry of the agent and endpoints haven't changed
+				r.telemetry.endLookup(span, tgt, lastIndex, len(ss), nil)
+				span.End()
+				continue
+			}
+
+			ee := make([]endpoint, 0, len(ss))
 			for _, s := range ss {
-				address := s.Service.Address
-				if s.Service.Address == "" {
-					address = s.Node.Address
+				if r.filter != nil && !r.filter(s) {
+					continue
 				}
-				ee = append(ee, fmt.Sprintf("%s:%d", address, s.Service.Port))
+				e := newEndpoint(s, &tgt)
+				if tgt.selectsChecks() {
+					e.Status = checksStatus(s.Checks, tgt.Checks, tgt.IgnoreChecks)
+					if len(tgt.Health) == 0 && e.Status != api.HealthPassing {
+						continue
+					}
+				}
+				ee = append(ee, e)
 			}
 
+			ee = filterHealth(ee, tgt.Health)
+			if len(tgt.PreferZone) > 0 && tgt.PreferZone != agentZone {
+				ee = preferZone(ee, tgt.PreferZone, tgt.ZoneKey, tgt.ZoneMinHealthy, tgt.ZoneLimit)
+			}
+			if tgt.Locality {
+				for i := range ee {
+					ee[i].Priority = localityPriority(localityOf(ee[i], tgt.ZoneKey), tgt.local)
+				}
+			}
+			ee = rendezvousSubset(ee, tgt.Subset, tgt.ClientID)
 			if tgt.Limit != 0 && len(ee) > tgt.Limit {
 				ee = ee[:tgt.Limit]
 			}
+			r.telemetry.endLookup(span, tgt, meta.LastIndex, len(ee), nil)
 			select {
-			case res <- ee:
-				continue
+			case res <- update{span: span, endpoints: ee}:
 			case <-quit:
+				span.End()
 				return
 			}
 		}
@@ -115,8 +229,8 @@ func watchConsulService(ctx context.Context, s servicer, tgt target, out chan<-
 			return
 		}
 		select {
-		case ee := <-res:
-			out <- ee
+		case u := <-res:
+			out <- u
 		case <-ctx.Done():
 			close(quit)
 			return
@@ -124,29 +238,287 @@ func watchConsulService(ctx context.Context, s servicer, tgt target, out chan<-
 	}
 }
 
-func populateEndpoints(ctx context.Context, clientConn resolver.ClientConn, input <-chan []string) {
+// agentRetryMax is the longest pause between the retries of the unavailable agent
+const agentRetryMax = time.Minute
+
+// agentDefaults resolves the target settings which wait for the local agent.
+// While the agent is unavailable they are retried on their own schedule and the lookups keep blocking;
+// the settings which the agent doesn't have are disabled once.
+type agentDefaults struct {
+	r        *resolvr
+	zone     bool // 'prefer-zone=_agent' is unresolved
+	wan      bool // 'wan' waits for the datacenter of the agent
+	locality bool // 'locality' waits for the locality of the agent
+	bck      *backoff.Backoff
+	retryAt  time.Time
+}
+
+func (r *resolvr) newAgentDefaults(tgt *target) *agentDefaults {
+	d := &agentDefaults{
+		r:        r,
+		zone:     tgt.PreferZone == agentZone,
+		wan:      true,
+		locality: tgt.Locality,
+		bck: &backoff.Backoff{
+			Factor: 2,
+			Jitter: true,
+			Min:    tgt.MaxBackoff,
+			Max:    agentRetryMax,
+		},
+	}
+	if r.agent == nil && d.zone {
+		r.log.Log(LevelWarn, "Zone preference is disabled without the agent API", tgt.logFields()...)
+		tgt.PreferZone = ""
+		d.zone = false
+	}
+	if r.agent == nil {
+		// Only the forced WAN doesn't need the agent
+		if tgt.WAN == "true" && len(tgt.TaggedAddress) == 0 {
+			tgt.TaggedAddress = wanTaggedAddresses
+		}
+		d.wan = false
+		if d.locality {
+			// Only the explicit zone is known without the agent
+			tgt.local = Locality{Zone: tgt.PreferZone}
+			d.locality = false
+		}
+	}
+	return d
+}
+
+// pending is true while some of the settings wait for the agent
+func (d *agentDefaults) pending() bool {
+	return d.zone || d.wan || d.locality
+}
+
+// apply resolves the pending settings when their retry is due.
+// It's true when any of them has changed the target.
+func (d *agentDefaults) apply(tgt *target, now time.Time) bool {
+	if !d.pending() || now.Before(d.retryAt) {
+		return false
+	}
+	var changed bool
+	if d.zone {
+		zone, err := localZone(d.r.agent, tgt.ZoneKey)
+		switch {
+		case err == nil:
+			tgt.PreferZone, d.zone, changed = zone, false, true
+		case isMissingSetting(err):
+			d.r.log.Log(LevelWarn, "Zone preference is disabled", tgt.logFields("error", err)...)
+			tgt.PreferZone, d.zone = "", false
+		default:
+			d.r.log.Log(LevelWarn, "Zone preference is off until the agent is available", tgt.logFields("error", err)...)
+		}
+	}
+	if d.wan {
+		wan, err := tgt.useWAN(d.r.agent)
+		switch {
+		case err == nil:
+			d.wan = false
+			if wan {
+				tgt.TaggedAddress, changed = wanTaggedAddresses, true
+			}
+		case isMissingSetting(err):
+			d.r.log.Log(LevelWarn, "Couldn't detect the remote datacenter. LAN addresses are used", tgt.logFields("error", err)...)
+			d.wan = false
+		default:
+			d.r.log.Log(LevelWarn, "LAN addresses are used until the agent is available", tgt.logFields("error", err)...)
+		}
+	}
+	if d.locality {
+		l, err := localLocality(d.r.agent, tgt)
+		if err == nil {
+			tgt.local, d.locality, changed = l, false, true
+		} else {
+			d.r.log.Log(LevelWarn, "Localities have the same priority until the agent is available", tgt.logFields("error", err)...)
+		}
+	}
+	if d.pending() {
+		d.retryAt = now.Add(d.bck.Duration())
+	}
+	return changed
+}
+
+// waitTime cuts the wait of the blocking query to the next retry of the pending settings
+func (d *agentDefaults) waitTime(wait time.Duration, now time.Time) time.Duration {
+	if !d.pending() {
+		return wait
+	}
+	if untilRetry := d.retryAt.Sub(now); wait == 0 || untilRetry < wait {
+		return untilRetry
+	}
+	return wait
+}
+
+// localLocality returns the locality of the local agent which ranks the localities of the endpoints.
+// The zone of 'prefer-zone' wins over the zone of the agent. Settings which the agent doesn't have stay empty.
+func localLocality(a agentSelfer, tgt *target) (Locality, error) {
+	l := Locality{Zone: tgt.PreferZone}
+	if l.Zone == agentZone {
+		l.Zone = ""
+	}
+	dc, err := localDatacenter(a)
+	if err != nil && !isMissingSetting(err) {
+		return Locality{}, err
+	}
+	l.Datacenter = dc
+	if len(l.Zone) == 0 {
+		zone, err := localZone(a, tgt.ZoneKey)
+		if err != nil && !isMissingSetting(err) {
+			return Locality{}, err
+		}
+		l.Zone = zone
+	}
+	return l, nil
+}
+
+func (r *resolvr) populateEndpoints(ctx context.Context, clientConn resolver.ClientConn, input <-chan update) {
+	var prev []resolver.Address
+	var prevOrder []string
+	var serviceConfig *serviceconfig.ParseResult
+	if len(r.tgt.SplitGroups) > 0 {
+		serviceConfig = clientConn.ParseServiceConfig(splitServiceConfig(r.tgt.SplitGroups))
+	}
+	var (
+		drain  *drainer
+		live   []endpoint
+		expire <-chan time.Time
+	)
+	if r.tgt.RemovalGrace > 0 {
+		drain = newDrainer(r.tgt.RemovalGrace)
+	}
 	for {
+		var span trace.Span
 		select {
-		case cc := <-input:
-			connsSet := make(map[string]struct{}, len(cc))
-			for _, c := range cc {
-				connsSet[c] = struct{}{}
-			}
-			conns := make([]resolver.Address, 0, len(connsSet))
-			for c := range connsSet {
-				conns = append(conns, resolver.Address{Addr: c})
+		case u := <-input:
+			span = u.span
+			seen := make(map[string]struct{}, len(u.endpoints))
+			live = make([]endpoint, 0, len(u.endpoints))
+			for _, e := range u.endpoints {
+				if _, ok := seen[e.Addr]; !ok {
+					seen[e.Addr] = struct{}{}
+					live = append(live, e)
+				}
 			}
-			sort.Sort(byAddressString(conns)) // Don't replace the same address list in the balancer
-			err := clientConn.UpdateState(resolver.State{Addresses: conns})
-			if err != nil {
-				grpclog.Errorf("[Consul resolver] Couldn't update client connection. error={%v}", err)
-				continue
+			if r.tgt.keepsOrder() {
+				live = orderEndpoints(live, r.tgt.Order, r.tgt.ClientID, prevOrder)
+				prevOrder = addrsOf(live)
 			}
+		case <-expire:
+			// Draining endpoints have expired
+			span = trace.SpanFromContext(ctx)
 		case <-ctx.Done():
-			grpclog.Info("[Consul resolver] Watch has been finished")
+			r.log.Log(LevelInfo, "Watch has been finished", r.tgt.logFields()...)
 			return
 		}
+
+		ee := live
+		if drain != nil {
+			var expires time.Time
+			ee, expires = drain.apply(live, time.Now())
+			expire = nil
+			if !expires.IsZero() {
+				expire = time.After(time.Until(expires))
+			}
+		}
+		connsSet := make(map[string]endpoint, len(ee))
+		for _, e := range ee {
+			connsSet[e.Addr] = e
+		}
+		state := r.clientState(ee)
+		state.ServiceConfig = serviceConfig
+		if r.tgt.Locality {
+			state.ServiceConfig = clientConn.ParseServiceConfig(localityServiceConfig(ee, r.tgt.ZoneKey))
+		}
+		conns := state.Addresses
+		err := clientConn.UpdateState(state)
+		r.telemetry.recordUpdate(span, r.tgt, len(conns), err)
+		r.state.pushed(connsSet, err)
+		if err != nil {
+			r.log.Log(LevelError, "Couldn't update client connection", r.tgt.logFields("error", err)...)
+			continue
+		}
+		added, removed, changed := diffAddresses(prev, conns)
+		prev = conns
+		if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
+			continue
+		}
+		r.log.Log(LevelInfo, "Endpoints updated", r.tgt.logFields(
+			"count", len(conns),
+			"added", addrStrings(added),
+			"removed", addrStrings(removed),
+			"changed", addrStrings(changed),
+		)...)
+		r.runHooks(EndpointsUpdate{
+			Target:    r.grpcTarget,
+			Addresses: conns,
+			Added:     added,
+			Removed:   removed,
+			Changed:   changed,
+		})
+	}
+}
+
+// clientState builds the state for cc.UpdateState from the deduplicated endpoints.
+// Addresses are sorted lexically unless the 'order' parameter keeps the order of the endpoints.
+// Endpoints out of the split groups are skipped for the split targets.
+// In the dual-stack mode every endpoint is also pushed as resolver.Endpoint with all its addresses
+// and Addresses has all of them for the balancers which don't support endpoints yet.
+func (r *resolvr) clientState(ee []endpoint) resolver.State {
+	var state resolver.State
+	state.Addresses = make([]resolver.Address, 0, len(ee))
+	for _, e := range ee {
+		addr := r.address(e)
+		if len(r.tgt.SplitGroups) > 0 {
+			g, ok := splitGroupOf(e, r.tgt.SplitGroups)
+			if !ok {
+				continue
+			}
+			addr = setSplitGroup(addr, g)
+		}
+		state.Addresses = append(state.Addresses, addr)
+		for _, alt := range e.AltAddrs {
+			a := addr
+			a.Addr = alt
+			state.Addresses = append(state.Addresses, a)
+		}
+		if r.tgt.DualStack {
+			ep := resolver.Endpoint{
+				Addresses:  []resolver.Address{{Addr: e.Addr, ServerName: addr.ServerName}},
+				Attributes: addr.BalancerAttributes,
+			}
+			for _, alt := range e.AltAddrs {
+				ep.Addresses = append(ep.Addresses, resolver.Address{Addr: alt, ServerName: addr.ServerName})
+			}
+			state.Endpoints = append(state.Endpoints, ep)
+		}
+	}
+	if r.tgt.keepsOrder() {
+		return state
+	}
+	sort.Sort(byAddressString(state.Addresses)) // Don't replace the same address list in the balancer
+	sort.Slice(state.Endpoints, func(i, j int) bool {
+		return state.Endpoints[i].Addresses[0].Addr < state.Endpoints[j].Addresses[0].Addr
+	})
+	return state
+}
+
+// address converts the endpoint to resolver.Address with the attributes for the balancer
+func (r *resolvr) address(e endpoint) resolver.Address {
+	addr := resolver.Address{Addr: e.Addr, ServerName: r.serverName(e)}
+	if r.tgt.Locality {
+		addr = setLocality(addr, e, r.tgt.ZoneKey)
+	}
+	if len(r.tgt.Health) > 0 {
+		addr = setHealthStatus(addr, e)
+	}
+	if len(r.tgt.Services) > 0 {
+		addr = setSourceService(addr, e)
+	}
+	if e.Draining {
+		addr = setDraining(addr)
 	}
+	return addr
 }
 
 // byAddressString sorts resolver.Address by Address Field  sorting in increasing order.
@@ -2,14 +2,40 @@ package consul
 
 import (
 	"context"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/require"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc/resolver"
 )
 
+// newTestResolvr returns resolvr with no-op telemetry and default logger
+func newTestResolvr(t *testing.T, tgt target) *resolvr {
+	t.Helper()
+	tel, err := newTelemetry(nil, nil)
+	require.NoError(t, err)
+	return &resolvr{tgt: tgt, telemetry: tel, log: NewGrpcLogger(), state: &resolverState{}}
+}
+
+// waitForAddresses returns the addresses of the first state pushed to the client connection
+func waitForAddresses(t *testing.T, cc *ClientConnMock, timeout time.Duration) []resolver.Address {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		if calls := cc.UpdateStateCalls(); len(calls) > 0 {
+			return calls[0].State.Addresses
+		}
+		select {
+		case <-deadline:
+			t.Fatal("no state update")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
 func TestPopulateEndpoints(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -33,8 +59,9 @@ func TestPopulateEndpoints(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var (
-				in = make(chan []string, len(tt.input))
+				in = make(chan update, len(tt.input))
 			)
+			r := newTestResolvr(t, target{})
 
 			fcc := &ClientConnMock{
 				UpdateStateFunc: func(state resolver.State) error {
@@ -45,8 +72,8 @@ func TestPopulateEndpoints(t *testing.T) {
 
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
-			go populateEndpoints(ctx, fcc, in)
-			in <- tt.input
+			go r.populateEndpoints(ctx, fcc, in)
+			in <- update{span: tracenoop.Span{}, endpoints: endpointsOf(tt.input...)}
 			time.Sleep(time.Millisecond)
 
 			require.Equal(t, 1, len(fcc.UpdateStateCalls()))
@@ -80,14 +107,15 @@ func TestWatchConsulService(t *testing.T) {
 
 			var (
 				got []string
-				out = make(chan []string)
+				out = make(chan update)
 			)
 			go func() {
 				for {
 					select {
 					case <-ctx.Done():
 						return
-					case got = <-out:
+					case u := <-out:
+						got = addrsOf(u.endpoints)
 					}
 				}
 			}()
@@ -106,10 +134,26 @@ func TestWatchConsulService(t *testing.T) {
 				},
 			}
 
-			go watchConsulService(ctx, fconsul, tt.tgt, out)
+			r := newTestResolvr(t, tt.tgt)
+			go r.watchConsulService(ctx, fconsul, out)
 			time.Sleep(5 * time.Millisecond)
 
 			require.Equal(t, tt.want, got)
 		})
 	}
 }
+
+func endpointsOf(addrs ...string) []endpoint {
+	ee := make([]endpoint, 0, len(addrs))
+	for _, a := range addrs {
+		ee = append(ee, endpoint{Addr: a})
+	}
+	return ee
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	require.NoError(t, err)
+	return u
+}
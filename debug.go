@@ -0,0 +1,167 @@
+package consul
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces secrets in the debug output
+const redacted = "REDACTED"
+
+// live resolvers for the debug handler
+var (
+	liveMu        sync.Mutex
+	liveResolvers = map[*resolvr]struct{}{}
+)
+
+func registerResolver(r *resolvr) {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	liveResolvers[r] = struct{}{}
+}
+
+func unregisterResolver(r *resolvr) {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	delete(liveResolvers, r)
+}
+
+// resolverState is the live state of the resolver exposed by the debug handler.
+// lastError is the error since the last successful lookup.
+type resolverState struct {
+	mu        sync.Mutex
+	endpoints []endpoint
+	lastIndex uint64
+	lastFetch time.Time
+	lastError error
+	backoff   time.Duration
+	updates   int
+}
+
+func (s *resolverState) lookupSucceeded(index uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastIndex = index
+	s.lastFetch = time.Now()
+	s.lastError = nil
+	s.backoff = 0
+}
+
+func (s *resolverState) lookupFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err
+}
+
+func (s *resolverState) backingOff(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backoff = d
+}
+
+// pushed records the endpoints passed to cc.UpdateState
+func (s *resolverState) pushed(ee map[string]endpoint, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastError = err
+		return
+	}
+	s.endpoints = s.endpoints[:0]
+	for _, e := range ee {
+		s.endpoints = append(s.endpoints, e)
+	}
+	sort.Slice(s.endpoints, func(i, j int) bool { return s.endpoints[i].Addr < s.endpoints[j].Addr })
+	s.updates++
+}
+
+// resolverStatus is a snapshot of the resolver for the debug handler
+type resolverStatus struct {
+	Target    string     `json:"target"`
+	Params    target     `json:"params"`
+	Endpoints []endpoint `json:"endpoints"`
+	LastIndex uint64     `json:"last_index"`
+	LastFetch time.Time  `json:"last_fetch"`
+	LastError string     `json:"last_error,omitempty"`
+	Backoff   string     `json:"backoff"`
+	Updates   int        `json:"updates"`
+}
+
+func (r *resolvr) status() resolverStatus {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	st := resolverStatus{
+		Target:    redactedURL(r.grpcTarget.URL),
+		Params:    r.tgt.redacted(),
+		Endpoints: append([]endpoint(nil), r.state.endpoints...),
+		LastIndex: r.state.lastIndex,
+		LastFetch: r.state.lastFetch,
+		Backoff:   r.state.backoff.String(),
+		Updates:   r.state.updates,
+	}
+	if r.state.lastError != nil {
+		st.LastError = r.state.lastError.Error()
+	}
+	return st
+}
+
+// redactedURL returns the URL with the password and the 'token' parameter redacted
+func redactedURL(u url.URL) string {
+	if q := u.Query(); q.Has("token") {
+		q.Set("token", redacted)
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// DebugHandler returns http.Handler which shows the state of all live resolvers.
+// It responds with JSON for '?format=json' or 'Accept: application/json' and with HTML otherwise.
+func DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		liveMu.Lock()
+		ss := make([]resolverStatus, 0, len(liveResolvers))
+		for r := range liveResolvers {
+			ss = append(ss, r.status())
+		}
+		liveMu.Unlock()
+		sort.Slice(ss, func(i, j int) bool { return ss[i].Target < ss[j].Target })
+
+		if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(ss)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = debugTemplate.Execute(w, ss)
+	})
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Consul resolvers</title></head>
+<body>
+<h1>Consul resolvers ({{len .}})</h1>
+{{range .}}
+<h2>{{.Target}}</h2>
+<table>
+<tr><th align="left">Params</th><td>{{printf "%+v" .Params}}</td></tr>
+<tr><th align="left">Last index</th><td>{{.LastIndex}}</td></tr>
+<tr><th align="left">Last fetch</th><td>{{.LastFetch}}</td></tr>
+<tr><th align="left">Last error</th><td>{{.LastError}}</td></tr>
+<tr><th align="left">Backoff</th><td>{{.Backoff}}</td></tr>
+<tr><th align="left">Updates</th><td>{{.Updates}}</td></tr>
+</table>
+<table border="1">
+<tr><th>Address</th><th>Node</th><th>ID</th><th>Status</th><th>Tags</th><th>Meta</th><th>Node meta</th></tr>
+{{range .Endpoints}}<tr><td>{{.Addr}}</td><td>{{.Node}}</td><td>{{.ID}}</td><td>{{.Status}}</td><td>{{.Tags}}</td><td>{{.Meta}}</td><td>{{.NodeMeta}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
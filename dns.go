@@ -0,0 +1,143 @@
+package consul
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// Values of the 'transport' URL parameter
+const (
+	transportHTTP = "http"
+	transportDNS  = "dns"
+)
+
+// defaultPollInterval is the polling interval of the sources without blocking queries
+// when 'wait' is not set
+const defaultPollInterval = 30 * time.Second
+
+// poller spaces out lookups of the sources without blocking queries:
+// every lookup of the service but the first waits for the interval.
+type poller struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newPoller(wait time.Duration) *poller {
+	if wait == 0 {
+		wait = defaultPollInterval
+	}
+	return &poller{interval: wait, last: map[string]time.Time{}}
+}
+
+// wait blocks until the interval since the previous lookup of the service has passed
+func (p *poller) wait(service string) {
+	p.mu.Lock()
+	next := time.Now()
+	if last, ok := p.last[service]; ok && last.Add(p.interval).After(next) {
+		next = last.Add(p.interval)
+	}
+	p.last[service] = next
+	p.mu.Unlock()
+	time.Sleep(time.Until(next))
+}
+
+// dnsServicer implements servicer with SRV lookups through the Consul DNS interface.
+// DNS has no blocking queries so it polls.
+// Consul DNS returns only the instances which aren't critical.
+type dnsServicer struct {
+	resolver *net.Resolver
+	domain   string
+	poller   *poller
+}
+
+func newDNSServicer(tgt target) *dnsServicer {
+	server := tgt.DNSServer
+	d := &net.Dialer{Timeout: tgt.Timeout}
+	return &dnsServicer{
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, network, server)
+			},
+		},
+		domain: tgt.DNSDomain,
+		poller: newPoller(tgt.Wait),
+	}
+}
+
+// Service looks up '[tag.]service.service[.dc].domain' SRV records.
+// passingOnly and the query options except Datacenter are not supported by DNS.
+func (d *dnsServicer) Service(service, tag string, _ bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	start := time.Now()
+	d.poller.wait(service)
+
+	labels := []string{service, "service"}
+	if len(tag) > 0 {
+		labels = append([]string{tag}, labels...)
+	}
+	if q != nil && len(q.Datacenter) > 0 {
+		labels = append(labels, q.Datacenter)
+	}
+	name := strings.Join(append(labels, d.domain), ".") + "."
+
+	ctx := context.Background()
+	_, srvs, err := d.resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Couldn't lookup '%s'", name)
+	}
+	ss := make([]*api.ServiceEntry, 0, len(srvs))
+	for _, srv := range srvs {
+		hosts, err := d.resolver.LookupHost(ctx, srv.Target)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Couldn't lookup '%s'", srv.Target)
+		}
+		if len(hosts) == 0 {
+			return nil, nil, errors.Errorf("no addresses for '%s'", srv.Target)
+		}
+		ss = append(ss, &api.ServiceEntry{
+			Node: &api.Node{
+				Node:    strings.TrimSuffix(srv.Target, "."),
+				Address: hosts[0],
+			},
+			Service: &api.AgentService{
+				Service: service,
+				Address: hosts[0],
+				Port:    int(srv.Port),
+			},
+		})
+	}
+	return ss, &api.QueryMeta{RequestTime: time.Since(start)}, nil
+}
+
+// fallbackServicer asks the Consul HTTP API and falls back to the DNS interface when it fails
+type fallbackServicer struct {
+	primary  servicer
+	fallback servicer
+	log      Logger
+}
+
+func (f *fallbackServicer) Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	ss, meta, err := f.primary.Service(service, tag, passingOnly, q)
+	if err == nil {
+		return ss, meta, nil
+	}
+	f.log.Log(LevelWarn, "Consul HTTP API is unavailable. Falling back to DNS", "service", service, "error", err)
+	ss, meta, dnsErr := f.fallback.Service(service, tag, passingOnly, q)
+	if dnsErr != nil {
+		return nil, nil, errors.Wrapf(err, "DNS fallback failed: %v", dnsErr)
+	}
+	if meta == nil {
+		meta = &api.QueryMeta{}
+	}
+	// Zero index makes the next HTTP query non-blocking
+	meta.LastIndex = 0
+	return ss, meta, nil
+}
@@ -0,0 +1,169 @@
+package consul
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/grpc/resolver"
+)
+
+// newFakeDNS starts UDP DNS server which answers like the Consul DNS interface.
+// srv maps SRV names to 'target:port' records, hosts maps targets to IPv4 addresses.
+// Targets with empty address exist but have no records.
+func newFakeDNS(t *testing.T, srv map[string][]string, hosts map[string]string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) == 0 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true, RCode: dnsmessage.RCodeNameError},
+				Questions: req.Questions,
+			}
+			name := strings.ToLower(q.Name.String())
+			hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 0}
+			switch q.Type {
+			case dnsmessage.TypeSRV:
+				for _, rec := range srv[name] {
+					host, port, _ := net.SplitHostPort(rec)
+					p, _ := net.LookupPort("tcp", port)
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{
+						Header: hdr,
+						Body: &dnsmessage.SRVResource{
+							Target: dnsmessage.MustNewName(host),
+							Port:   uint16(p),
+						},
+					})
+				}
+			case dnsmessage.TypeA:
+				if ip := hosts[name]; len(ip) > 0 {
+					var a [4]byte
+					copy(a[:], net.ParseIP(ip).To4())
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: a}})
+				}
+			}
+			_, isHost := hosts[name]
+			if len(resp.Answers) > 0 || isHost || srv[name] != nil {
+				resp.RCode = dnsmessage.RCodeSuccess
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(out, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestDNSServicer(t *testing.T) {
+	server := newFakeDNS(t,
+		map[string][]string{
+			"svc.service.consul.":             {"node-1.node.dc1.consul.:8080", "node-2.node.dc1.consul.:8081"},
+			"primary.svc.service.dc2.consul.": {"node-3.node.dc2.consul.:9090"},
+			"no-hosts.service.consul.":        {"node-4.node.dc1.consul.:8080"},
+		},
+		map[string]string{
+			"node-1.node.dc1.consul.": "10.0.0.1",
+			"node-2.node.dc1.consul.": "10.0.0.2",
+			"node-3.node.dc2.consul.": "10.0.0.3",
+			"node-4.node.dc1.consul.": "", // exists without records
+		},
+	)
+	d := newDNSServicer(target{DNSServer: server, DNSDomain: "consul", Wait: 20 * time.Millisecond, Timeout: time.Second})
+
+	start := time.Now()
+	ss, _, err := d.Service("svc", "", false, &api.QueryOptions{})
+	require.NoError(t, err)
+	var got []string
+	for _, s := range ss {
+		got = append(got, newEndpoint(s, &target{}).Addr)
+	}
+	require.ElementsMatch(t, []string{"10.0.0.1:8080", "10.0.0.2:8081"}, got)
+
+	ss, _, err = d.Service("svc", "primary", false, &api.QueryOptions{Datacenter: "dc2"})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "next lookup waits for the interval")
+	require.Len(t, ss, 1)
+	require.Equal(t, "10.0.0.3", ss[0].Service.Address)
+	require.Equal(t, 9090, ss[0].Service.Port)
+	require.Equal(t, "node-3.node.dc2.consul", ss[0].Node.Node)
+
+	ss, meta, err := d.Service("no-hosts", "", false, &api.QueryOptions{})
+	require.Error(t, err, "SRV target without A/AAAA records")
+	require.Nil(t, ss)
+	require.Nil(t, meta)
+
+	start = time.Now()
+	_, _, err = d.Service("unknown", "", false, &api.QueryOptions{})
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 20*time.Millisecond, "other services don't wait")
+}
+
+func TestFallbackServicer(t *testing.T) {
+	entries := []*api.ServiceEntry{{Service: &api.AgentService{Address: "10.0.0.1", Port: 8080}}}
+	ok := &servicerMock{ServiceFunc: func(string, string, bool, *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+		return entries, &api.QueryMeta{LastIndex: 42}, nil
+	}}
+	broken := &servicerMock{ServiceFunc: func(string, string, bool, *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+		return nil, nil, errors.New("unavailable")
+	}}
+
+	ss, meta, err := (&fallbackServicer{primary: ok, fallback: broken, log: NewGrpcLogger()}).Service("svc", "", false, nil)
+	require.NoError(t, err)
+	require.Equal(t, entries, ss)
+	require.Equal(t, uint64(42), meta.LastIndex)
+	require.Empty(t, broken.ServiceCalls())
+
+	ss, meta, err = (&fallbackServicer{primary: broken, fallback: ok, log: NewGrpcLogger()}).Service("svc", "", false, nil)
+	require.NoError(t, err)
+	require.Equal(t, entries, ss)
+	require.Equal(t, uint64(0), meta.LastIndex, "next HTTP query must not block")
+
+	_, _, err = (&fallbackServicer{primary: broken, fallback: broken, log: NewGrpcLogger()}).Service("svc", "", false, nil)
+	require.Error(t, err)
+
+	noMeta := &servicerMock{ServiceFunc: func(string, string, bool, *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+		return entries, nil, nil
+	}}
+	_, meta, err = (&fallbackServicer{primary: broken, fallback: noMeta, log: NewGrpcLogger()}).Service("svc", "", false, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), meta.LastIndex)
+}
+
+func TestBuilderDNSTransport(t *testing.T) {
+	server := newFakeDNS(t,
+		map[string][]string{"svc.service.consul.": {"node-1.node.dc1.consul.:8080"}},
+		map[string]string{"node-1.node.dc1.consul.": "10.0.0.1"},
+	)
+	fcc := &ClientConnMock{UpdateStateFunc: func(resolver.State) error { return nil }}
+	r, err := NewBuilder().Build(resolver.Target{URL: url.URL{
+		Scheme:   schemeName,
+		Host:     "unreachable:8500",
+		Path:     "/svc",
+		RawQuery: "transport=dns&dns-server=" + server,
+	}}, fcc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Equal(t, []resolver.Address{{Addr: "10.0.0.1:8080"}}, waitForAddresses(t, fcc, time.Second))
+}
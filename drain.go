@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// drainer keeps the departed endpoints in the list for the 'removal-grace' period.
+// Cooperating balancers stop sending new RPCs to the draining addresses
+// while the streams which are already open can finish.
+type drainer struct {
+	grace    time.Duration
+	live     map[string]endpoint
+	departed map[string]departedEndpoint
+}
+
+type departedEndpoint struct {
+	endpoint
+	until time.Time
+}
+
+func newDrainer(grace time.Duration) *drainer {
+	return &drainer{grace: grace, live: map[string]endpoint{}, departed: map[string]departedEndpoint{}}
+}
+
+// apply returns the live endpoints followed by the draining ones and the time when the next of them expires.
+// Endpoints which are absent in live since the previous call start draining;
+// draining endpoints which come back are live again.
+func (d *drainer) apply(live []endpoint, now time.Time) ([]endpoint, time.Time) {
+	next := make(map[string]endpoint, len(live))
+	for _, e := range live {
+		next[e.Addr] = e
+		delete(d.departed, e.Addr)
+	}
+	for addr, e := range d.live {
+		if _, ok := next[addr]; !ok {
+			e.Draining = true
+			d.departed[addr] = departedEndpoint{endpoint: e, until: now.Add(d.grace)}
+		}
+	}
+	d.live = next
+
+	draining := make([]endpoint, 0, len(d.departed))
+	var expires time.Time
+	for addr, de := range d.departed {
+		if !de.until.After(now) {
+			delete(d.departed, addr)
+			continue
+		}
+		draining = append(draining, de.endpoint)
+		if expires.IsZero() || de.until.Before(expires) {
+			expires = de.until
+		}
+	}
+	sort.Slice(draining, func(i, j int) bool { return draining[i].Addr < draining[j].Addr })
+	return append(append(make([]endpoint, 0, len(live)+len(draining)), live...), draining...), expires
+}
+
+type drainingKey struct{}
+
+// IsDraining is true when the address has left Consul and is kept only for the 'removal-grace' period.
+// Balancers shouldn't send new RPCs to it.
+func IsDraining(addr resolver.Address) bool {
+	d, _ := addr.BalancerAttributes.Value(drainingKey{}).(bool)
+	return d
+}
+
+// setDraining marks the address as draining
+func setDraining(addr resolver.Address) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(drainingKey{}, true)
+	return addr
+}
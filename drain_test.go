@@ -0,0 +1,65 @@
+package consul
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestDrainer(t *testing.T) {
+	now := time.Now()
+	d := newDrainer(time.Minute)
+
+	ee, expires := d.apply(endpointsOf("10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"), now)
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}, addrsOf(ee))
+	require.True(t, expires.IsZero())
+
+	ee, expires = d.apply(endpointsOf("10.0.0.1:80"), now)
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}, addrsOf(ee))
+	require.Equal(t, []bool{false, true, true}, []bool{ee[0].Draining, ee[1].Draining, ee[2].Draining})
+	require.Equal(t, now.Add(time.Minute), expires)
+
+	ee, expires = d.apply(endpointsOf("10.0.0.1:80", "10.0.0.3:80"), now.Add(30*time.Second))
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.3:80", "10.0.0.2:80"}, addrsOf(ee), "returned endpoint is live again")
+	require.Equal(t, []bool{false, false, true}, []bool{ee[0].Draining, ee[1].Draining, ee[2].Draining})
+	require.Equal(t, now.Add(time.Minute), expires)
+
+	ee, expires = d.apply(endpointsOf("10.0.0.1:80", "10.0.0.3:80"), now.Add(time.Minute))
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.3:80"}, addrsOf(ee))
+	require.True(t, expires.IsZero())
+}
+
+func TestPopulateEndpointsRemovalGrace(t *testing.T) {
+	states := make(chan resolver.State, 3)
+	fcc := &ClientConnMock{
+		UpdateStateFunc: func(state resolver.State) error {
+			states <- state
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan update)
+	r := newTestResolvr(t, target{RemovalGrace: 50 * time.Millisecond})
+	go r.populateEndpoints(ctx, fcc, in)
+
+	in <- update{span: tracenoop.Span{}, endpoints: endpointsOf("10.0.0.1:80", "10.0.0.2:80")}
+	<-states
+	in <- update{span: tracenoop.Span{}, endpoints: endpointsOf("10.0.0.1:80")}
+	state := <-states
+	require.Len(t, state.Addresses, 2)
+	require.False(t, IsDraining(state.Addresses[0]))
+	require.Equal(t, "10.0.0.2:80", state.Addresses[1].Addr)
+	require.True(t, IsDraining(state.Addresses[1]))
+
+	select {
+	case state = <-states:
+		require.Equal(t, []resolver.Address{{Addr: "10.0.0.1:80"}}, state.Addresses)
+	case <-time.After(time.Second):
+		t.Fatal("draining endpoint hasn't been removed")
+	}
+}
@@ -0,0 +1,84 @@
+package consul
+
+import (
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+)
+
+// Values of the 'health' URL parameter
+const (
+	// healthPassing uses passing endpoints and falls back to warning ones when there are no passing
+	healthPassing = "passing"
+	// healthWarning uses passing and warning endpoints
+	healthWarning = "warning"
+	// healthAny uses passing and warning endpoints alike without preferring passing ones
+	healthAny = "any"
+)
+
+// filterHealth selects endpoints by their aggregated status for the 'health' mode.
+// Critical and maintenance endpoints are excluded in all modes. Without the mode endpoints aren't filtered.
+func filterHealth(ee []endpoint, mode string) []endpoint {
+	if mode == "" {
+		return ee
+	}
+	var passing, usable []endpoint
+	for _, e := range ee {
+		switch e.Status {
+		case api.HealthPassing:
+			passing = append(passing, e)
+			usable = append(usable, e)
+		case api.HealthWarning:
+			usable = append(usable, e)
+		}
+	}
+	if mode == healthWarning || mode == healthAny || len(passing) == 0 {
+		return usable
+	}
+	return passing
+}
+
+type healthStatusKey struct{}
+
+// HealthStatusFromAddress returns the aggregated Consul health status of the endpoint
+// ('passing', 'warning', ...). It's attached to every address when the 'health' parameter is set.
+func HealthStatusFromAddress(addr resolver.Address) (string, bool) {
+	s, ok := addr.BalancerAttributes.Value(healthStatusKey{}).(string)
+	return s, ok
+}
+
+// setHealthStatus attaches the aggregated health status of the endpoint to the address
+func setHealthStatus(addr resolver.Address, e endpoint) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(healthStatusKey{}, e.Status)
+	return addr
+}
+
+// checksStatus aggregates only the checks selected by 'check' and not ignored by 'ignore-check'.
+// Checks are matched by ID or name. Maintenance of the node or the service can't be ignored.
+func checksStatus(checks api.HealthChecks, only, ignore []string) string {
+	selected := make(api.HealthChecks, 0, len(checks))
+	for _, c := range checks {
+		if c.CheckID == api.NodeMaint || strings.HasPrefix(c.CheckID, api.ServiceMaintPrefix) {
+			selected = append(selected, c)
+			continue
+		}
+		if len(only) > 0 && !matchCheck(c, only) {
+			continue
+		}
+		if matchCheck(c, ignore) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	return selected.AggregatedStatus()
+}
+
+func matchCheck(c *api.HealthCheck, names []string) bool {
+	for _, n := range names {
+		if c.CheckID == n || c.Name == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package consul
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+)
+
+func Test_filterHealth(t *testing.T) {
+	var (
+		p = endpoint{Addr: "p", Status: api.HealthPassing}
+		w = endpoint{Addr: "w", Status: api.HealthWarning}
+		c = endpoint{Addr: "c", Status: api.HealthCritical}
+		m = endpoint{Addr: "m", Status: api.HealthMaint}
+	)
+	tests := []struct {
+		name  string
+		mode  string
+		input []endpoint
+		want  []endpoint
+	}{
+		{"unset", "", []endpoint{p, w, c}, []endpoint{p, w, c}},
+		{"any", healthAny, []endpoint{p, w, c, m}, []endpoint{p, w}},
+		{"any-critical", healthAny, []endpoint{c, w}, []endpoint{w}},
+		{"warning", healthWarning, []endpoint{c, w, p, m}, []endpoint{w, p}},
+		{"passing", healthPassing, []endpoint{c, w, p}, []endpoint{p}},
+		{"passing-fallback", healthPassing, []endpoint{c, w, m}, []endpoint{w}},
+		{"passing-none", healthPassing, []endpoint{c, m}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, filterHealth(tt.input, tt.mode))
+		})
+	}
+}
+
+func TestHealthStatusFromAddress(t *testing.T) {
+	addr := setHealthStatus(resolver.Address{Addr: "127.0.0.1:1"}, endpoint{Status: api.HealthWarning})
+	s, ok := HealthStatusFromAddress(addr)
+	require.True(t, ok)
+	require.Equal(t, api.HealthWarning, s)
+
+	_, ok = HealthStatusFromAddress(resolver.Address{Addr: "127.0.0.1:1"})
+	require.False(t, ok)
+}
+
+func Test_checksStatus(t *testing.T) {
+	var (
+		serf   = &api.HealthCheck{CheckID: "serfHealth", Name: "Serf Health Status", Status: api.HealthPassing}
+		grpc   = &api.HealthCheck{CheckID: "service:svc-1", Name: "grpc", Status: api.HealthPassing}
+		disk   = &api.HealthCheck{CheckID: "disk", Name: "Disk space", Status: api.HealthCritical}
+		memory = &api.HealthCheck{CheckID: "memory", Name: "Memory", Status: api.HealthWarning}
+		maint  = &api.HealthCheck{CheckID: api.NodeMaint, Status: api.HealthCritical}
+	)
+	tests := []struct {
+		name   string
+		checks api.HealthChecks
+		only   []string
+		ignore []string
+		want   string
+	}{
+		{"all", api.HealthChecks{serf, grpc, disk}, nil, nil, api.HealthCritical},
+		{"ignore-by-id", api.HealthChecks{serf, grpc, disk}, nil, []string{"disk"}, api.HealthPassing},
+		{"ignore-by-name", api.HealthChecks{serf, grpc, disk, memory}, nil, []string{"Disk space"}, api.HealthWarning},
+		{"only", api.HealthChecks{serf, grpc, disk, memory}, []string{"grpc"}, nil, api.HealthPassing},
+		{"only-and-ignore", api.HealthChecks{serf, grpc, memory}, []string{"grpc", "memory"}, []string{"memory"}, api.HealthPassing},
+		{"node-maintenance", api.HealthChecks{serf, grpc, maint}, []string{"grpc"}, []string{api.NodeMaint}, api.HealthMaint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, checksStatus(tt.checks, tt.only, tt.ignore))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package consul
+
+import (
+	"sort"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// EndpointsUpdate describes the endpoints pushed to the gRPC client connection
+type EndpointsUpdate struct {
+	// Target of the resolver
+	Target resolver.Target
+	// Addresses is the full new address list
+	Addresses []resolver.Address
+	// Added addresses are not in the previous list
+	Added []resolver.Address
+	// Removed addresses are not in the new list
+	Removed []resolver.Address
+	// Changed addresses are in both lists but have different attributes
+	Changed []resolver.Address
+}
+
+// UpdateHook is called after every update which changed the endpoints of the resolver
+type UpdateHook func(EndpointsUpdate)
+
+// runHooks calls every hook with u and sends it to the update channels.
+// A panic in the hook is logged and doesn't stop the resolver.
+func (r *resolvr) runHooks(u EndpointsUpdate) {
+	for _, ch := range r.updateChans {
+		select {
+		case ch <- u:
+		default:
+			r.log.Log(LevelWarn, "Update channel is full. Update is dropped", r.tgt.logFields()...)
+		}
+	}
+	for _, h := range r.hooks {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					r.log.Log(LevelError, "Update hook panicked", r.tgt.logFields("panic", p)...)
+				}
+			}()
+			h(u)
+		}()
+	}
+}
+
+// diffAddresses compares address lists by Addr.
+// Results are sorted in the same order as the lists.
+func diffAddresses(prev, next []resolver.Address) (added, removed, changed []resolver.Address) {
+	prevSet := make(map[string]resolver.Address, len(prev))
+	for _, a := range prev {
+		prevSet[a.Addr] = a
+	}
+	nextSet := make(map[string]struct{}, len(next))
+	for _, a := range next {
+		nextSet[a.Addr] = struct{}{}
+		p, ok := prevSet[a.Addr]
+		switch {
+		case !ok:
+			added = append(added, a)
+		case !p.Equal(a):
+			changed = append(changed, a)
+		}
+	}
+	for _, a := range prev {
+		if _, ok := nextSet[a.Addr]; !ok {
+			removed = append(removed, a)
+		}
+	}
+	return added, removed, changed
+}
+
+// addrStrings returns sorted Addr fields for the log messages
+func addrStrings(aa []resolver.Address) []string {
+	ss := make([]string, 0, len(aa))
+	for _, a := range aa {
+		ss = append(ss, a.Addr)
+	}
+	sort.Strings(ss)
+	return ss
+}
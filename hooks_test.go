@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+func Test_diffAddresses(t *testing.T) {
+	var (
+		a = resolver.Address{Addr: "127.0.0.1:1"}
+		b = resolver.Address{Addr: "127.0.0.1:2"}
+		c = resolver.Address{Addr: "127.0.0.1:3"}
+		d = resolver.Address{Addr: "127.0.0.1:3", BalancerAttributes: attributes.New("k", "v")}
+	)
+	added, removed, changed := diffAddresses([]resolver.Address{a, b, c}, []resolver.Address{b, d})
+	require.Empty(t, added)
+	require.Equal(t, []resolver.Address{a}, removed)
+	require.Equal(t, []resolver.Address{d}, changed)
+
+	added, removed, changed = diffAddresses(nil, []resolver.Address{a})
+	require.Equal(t, []resolver.Address{a}, added)
+	require.Empty(t, removed)
+	require.Empty(t, changed)
+}
+
+func TestHooks(t *testing.T) {
+	var (
+		in  = make(chan update)
+		ch  = make(chan EndpointsUpdate, 1)
+		rec = make(chan EndpointsUpdate, 10)
+	)
+	r := newTestResolvr(t, target{Service: "svc"})
+	r.grpcTarget = resolver.Target{URL: *mustParseURL(t, "consul://127.0.0.1:8500/svc")}
+	r.hooks = []UpdateHook{
+		func(EndpointsUpdate) { panic("bad hook") },
+		func(u EndpointsUpdate) { rec <- u },
+	}
+	r.updateChans = []chan<- EndpointsUpdate{ch}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.populateEndpoints(ctx, &ClientConnMock{UpdateStateFunc: func(resolver.State) error { return nil }}, in)
+
+	in <- update{span: tracenoop.Span{}, endpoints: endpointsOf("127.0.0.1:1", "127.0.0.1:2")}
+	in <- update{span: tracenoop.Span{}, endpoints: endpointsOf("127.0.0.1:2", "127.0.0.1:1")} // no changes
+	in <- update{span: tracenoop.Span{}, endpoints: endpointsOf("127.0.0.1:2", "127.0.0.1:3")}
+
+	// updates are handled in order: the second call is for the last update only when the unchanged one has no call
+	var got []EndpointsUpdate
+	for len(got) < 2 {
+		select {
+		case u := <-rec:
+			got = append(got, u)
+		case <-time.After(time.Second):
+			t.Fatalf("hooks are called %d times, want 2", len(got))
+		}
+	}
+	require.Empty(t, rec)
+	require.Equal(t, r.grpcTarget, got[0].Target)
+	require.Equal(t, []resolver.Address{{Addr: "127.0.0.1:1"}, {Addr: "127.0.0.1:2"}}, got[0].Added)
+	require.Equal(t, []resolver.Address{{Addr: "127.0.0.1:2"}, {Addr: "127.0.0.1:3"}}, got[1].Addresses)
+	require.Equal(t, []resolver.Address{{Addr: "127.0.0.1:3"}}, got[1].Added)
+	require.Equal(t, []resolver.Address{{Addr: "127.0.0.1:1"}}, got[1].Removed)
+
+	// the channel keeps the first update, the second one is dropped
+	require.Equal(t, got[0], <-ch)
+	require.Empty(t, ch)
+}
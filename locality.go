@@ -0,0 +1,205 @@
+package consul
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// LocalityBalancerName is the name of the balancer which routes RPCs by the locality priorities.
+// The resolver selects it in the service config of the 'locality' targets.
+const LocalityBalancerName = "consul_locality"
+
+func init() {
+	balancer.Register(localityBuilder{})
+}
+
+// Locality of the endpoint. It's attached to every address in the 'locality' mode.
+type Locality struct {
+	Datacenter string
+	Zone       string
+}
+
+func (l Locality) String() string {
+	return l.Datacenter + "/" + l.Zone
+}
+
+// localityOf returns the locality of the endpoint by its datacenter and the zone node meta
+func localityOf(e endpoint, zoneKey string) Locality {
+	return Locality{Datacenter: e.Datacenter, Zone: e.NodeMeta[zoneKey]}
+}
+
+// localityPriority ranks the locality against the local one:
+// 0 is the local zone, 1 is other zones of the local datacenter and 2 is other datacenters.
+// Unknown parts of the local locality don't make a difference.
+func localityPriority(l, local Locality) int {
+	if len(local.Datacenter) > 0 && l.Datacenter != local.Datacenter {
+		return 2
+	}
+	if len(local.Zone) > 0 && l.Zone != local.Zone {
+		return 1
+	}
+	return 0
+}
+
+func priorityName(p int) string {
+	return "priority-" + strconv.Itoa(p)
+}
+
+type localityKey struct{}
+
+// LocalityFromAddress returns the locality attached to the address by the resolver
+func LocalityFromAddress(addr resolver.Address) (Locality, bool) {
+	l, ok := addr.BalancerAttributes.Value(localityKey{}).(Locality)
+	return l, ok
+}
+
+type localityPathKey struct{}
+
+// LocalityPathFromAddress returns the hierarchical path of the address: its priority and locality,
+// like ["priority-0", "dc1/a"]. The path matches the names in the consul_locality config.
+func LocalityPathFromAddress(addr resolver.Address) ([]string, bool) {
+	p, ok := addr.BalancerAttributes.Value(localityPathKey{}).([]string)
+	return p, ok
+}
+
+// setLocality attaches the locality of the endpoint and its hierarchical path to the address
+func setLocality(addr resolver.Address, e endpoint, zoneKey string) resolver.Address {
+	l := localityOf(e, zoneKey)
+	addr.BalancerAttributes = addr.BalancerAttributes.
+		WithValue(localityKey{}, l).
+		WithValue(localityPathKey{}, []string{priorityName(e.Priority), l.String()})
+	return addr
+}
+
+// localityServiceConfig returns the service config of the locality target in the priority style.
+// Every priority is a weighted_target of its localities with the weight by the number of endpoints.
+func localityServiceConfig(ee []endpoint, zoneKey string) string {
+	cfg := localityConfig{Children: map[string]localityChild{}}
+	for _, e := range ee {
+		name := priorityName(e.Priority)
+		child, ok := cfg.Children[name]
+		if !ok {
+			child = localityChild{Targets: map[string]splitTarget{}}
+			cfg.Children[name] = child
+			cfg.Priorities = append(cfg.Priorities, name)
+		}
+		l := localityOf(e, zoneKey).String()
+		child.Targets[l] = splitTarget{Weight: child.Targets[l].Weight + 1}
+	}
+	sort.Strings(cfg.Priorities) // single-digit priorities sort lexically
+	js, _ := json.Marshal(map[string]interface{}{
+		"loadBalancingConfig": []map[string]localityConfig{{LocalityBalancerName: cfg}},
+	})
+	return string(js)
+}
+
+// localityConfig is the config of the locality balancer
+type localityConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	Priorities []string                 `json:"priorities"`
+	Children   map[string]localityChild `json:"children"`
+}
+
+type localityChild struct {
+	Targets map[string]splitTarget `json:"targets"`
+}
+
+// localityBuilder builds the locality balancer.
+// RPCs go to the first priority which has ready connections, so the failover
+// to the next priority and back happens inside the balancer without new connections.
+// Inside the priority localities get RPCs by their weights and round-robin.
+// Draining addresses get no new RPCs.
+type localityBuilder struct{}
+
+func (localityBuilder) Name() string { return LocalityBalancerName }
+
+func (localityBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	b := &localityBalancer{}
+	b.Balancer = base.NewBalancerBuilder(LocalityBalancerName, b, base.Config{HealthCheck: true}).Build(cc, opts)
+	return b
+}
+
+func (localityBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var cfg localityConfig
+	if err := json.Unmarshal(js, &cfg); err != nil {
+		return nil, errors.Wrap(err, "Malformed locality balancer config")
+	}
+	return &cfg, nil
+}
+
+// localityBalancer keeps the priorities and weights from the config and the paths of the addresses
+// for the picker which is built by the base balancer.
+type localityBalancer struct {
+	balancer.Balancer
+
+	mu         sync.Mutex
+	priorities []string
+	weights    map[string]map[string]uint32 // priority -> locality -> weight
+	paths      map[string][]string          // address -> path
+	draining   map[string]bool
+}
+
+func (b *localityBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.mu.Lock()
+	b.priorities = nil
+	b.weights = map[string]map[string]uint32{}
+	if cfg, ok := s.BalancerConfig.(*localityConfig); ok {
+		b.priorities = cfg.Priorities
+		for name, child := range cfg.Children {
+			b.weights[name] = make(map[string]uint32, len(child.Targets))
+			for l, t := range child.Targets {
+				b.weights[name][l] = t.Weight
+			}
+		}
+	}
+	b.paths = make(map[string][]string, len(s.ResolverState.Addresses))
+	b.draining = map[string]bool{}
+	for _, addr := range s.ResolverState.Addresses {
+		if p, ok := LocalityPathFromAddress(addr); ok && len(p) == 2 {
+			b.paths[addr.Addr] = p
+		}
+		if IsDraining(addr) {
+			b.draining[addr.Addr] = true
+		}
+	}
+	b.mu.Unlock()
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+// Build implements base.PickerBuilder
+func (b *localityBalancer) Build(info base.PickerBuildInfo) balancer.Picker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	byPath := map[string]map[string][]balancer.SubConn{}
+	for sc, sci := range info.ReadySCs {
+		p, ok := b.paths[sci.Address.Addr]
+		if !ok || b.draining[sci.Address.Addr] || b.weights[p[0]][p[1]] == 0 {
+			continue
+		}
+		if byPath[p[0]] == nil {
+			byPath[p[0]] = map[string][]balancer.SubConn{}
+		}
+		byPath[p[0]][p[1]] = append(byPath[p[0]][p[1]], sc)
+	}
+	for _, priority := range b.priorities {
+		if len(byPath[priority]) == 0 {
+			continue
+		}
+		p := &splitPicker{}
+		for l, scs := range byPath[priority] {
+			p.total += b.weights[priority][l]
+			p.groups = append(p.groups, &splitPickerGroup{upTo: p.total, subConns: scs})
+		}
+		return p
+	}
+	return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+}
@@ -0,0 +1,211 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+func TestLocality(t *testing.T) {
+	var (
+		in  = make(chan update, 1)
+		got = make(chan resolver.State, 1)
+	)
+	r := newTestResolvr(t, target{Locality: true, ZoneKey: "zone"})
+	fcc := &ClientConnMock{
+		ParseServiceConfigFunc: func(js string) *serviceconfig.ParseResult {
+			return &serviceconfig.ParseResult{Config: struct{ serviceconfig.Config }{}}
+		},
+		UpdateStateFunc: func(state resolver.State) error {
+			got <- state
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.populateEndpoints(ctx, fcc, in)
+
+	ee := []endpoint{
+		{Addr: "127.0.0.1:1", Datacenter: "dc1", NodeMeta: map[string]string{"zone": "a"}},
+		{Addr: "127.0.0.1:2", Datacenter: "dc2", Priority: 2},
+	}
+	in <- update{span: tracenoop.Span{}, endpoints: ee}
+	var state resolver.State
+	select {
+	case state = <-got:
+	case <-time.After(time.Second):
+		t.Fatal("no state update")
+	}
+	addrs := state.Addresses
+	require.Len(t, addrs, 2)
+	require.NotNil(t, state.ServiceConfig, "locality selects the locality balancer")
+	require.Equal(t, localityServiceConfig(ee, "zone"), fcc.ParseServiceConfigCalls()[0].ServiceConfigJSON)
+
+	l, ok := LocalityFromAddress(addrs[0])
+	require.True(t, ok)
+	require.Equal(t, Locality{Datacenter: "dc1", Zone: "a"}, l)
+	p, ok := LocalityPathFromAddress(addrs[0])
+	require.True(t, ok)
+	require.Equal(t, []string{"priority-0", "dc1/a"}, p)
+
+	l, ok = LocalityFromAddress(addrs[1])
+	require.True(t, ok)
+	require.Equal(t, Locality{Datacenter: "dc2"}, l)
+	p, ok = LocalityPathFromAddress(addrs[1])
+	require.True(t, ok)
+	require.Equal(t, []string{"priority-2", "dc2/"}, p)
+
+	_, ok = LocalityFromAddress(resolver.Address{Addr: "127.0.0.1:3"})
+	require.False(t, ok)
+}
+
+func TestParseURLLocality(t *testing.T) {
+	tgt, err := parseURL("consul://127.0.0.1:8500/svc?locality=true", target{})
+	require.NoError(t, err)
+	require.True(t, tgt.Locality)
+	require.Equal(t, "zone", tgt.ZoneKey)
+
+	_, err = parseURL("consul://127.0.0.1:8500/svc?locality=true&split=stable:95,canary:5", target{})
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, "locality", perr.Field)
+	_, err = parseURL("consul://127.0.0.1:8500/orders-v1;weight=9,orders-v2?locality=true", target{})
+	require.Error(t, err, "both need their own balancer")
+}
+
+func Test_localityPriority(t *testing.T) {
+	local := Locality{Datacenter: "dc1", Zone: "a"}
+	require.Equal(t, 0, localityPriority(Locality{Datacenter: "dc1", Zone: "a"}, local))
+	require.Equal(t, 1, localityPriority(Locality{Datacenter: "dc1", Zone: "b"}, local))
+	require.Equal(t, 2, localityPriority(Locality{Datacenter: "dc2", Zone: "a"}, local))
+	require.Equal(t, 0, localityPriority(Locality{Datacenter: "dc2", Zone: "b"}, Locality{}),
+		"unknown local locality ranks everything the same")
+}
+
+func Test_localLocality(t *testing.T) {
+	agent := fakeAgent{self: map[string]map[string]interface{}{
+		"Config": {"Datacenter": "dc1"},
+		"Meta":   {"zone": "a"},
+	}}
+	l, err := localLocality(agent, &target{ZoneKey: "zone"})
+	require.NoError(t, err)
+	require.Equal(t, Locality{Datacenter: "dc1", Zone: "a"}, l)
+	l, err = localLocality(agent, &target{ZoneKey: "zone", PreferZone: "b"})
+	require.NoError(t, err)
+	require.Equal(t, Locality{Datacenter: "dc1", Zone: "b"}, l)
+
+	l, err = localLocality(fakeAgent{self: map[string]map[string]interface{}{"Config": {"Datacenter": "dc1"}}}, &target{ZoneKey: "zone"})
+	require.NoError(t, err, "missing zone meta isn't retried")
+	require.Equal(t, Locality{Datacenter: "dc1"}, l)
+
+	_, err = localLocality(fakeAgent{err: errors.New("agent is down")}, &target{ZoneKey: "zone"})
+	require.Error(t, err)
+}
+
+func TestWatchLocalityRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fconsul := newBlockingServicer(ctx)
+	tgt, err := parseURL("consul://127.0.0.1:8500/svc?locality=true&max-backoff=20ms", target{})
+	require.NoError(t, err)
+	r := newTestResolvr(t, tgt)
+	r.agent = &flakyAgent{failures: 1, self: map[string]map[string]interface{}{"Meta": {"zone": "b"}}}
+	out := make(chan update)
+	go r.watchService(ctx, fconsul, tgt, out)
+
+	priorities := func() []int {
+		select {
+		case u := <-out:
+			var pp []int
+			for _, e := range u.endpoints {
+				pp = append(pp, e.Priority)
+			}
+			return pp
+		case <-time.After(time.Second):
+			t.Fatal("no update")
+		}
+		return nil
+	}
+	require.Equal(t, []int{0, 0}, priorities(), "same priority while the agent is down")
+	require.Equal(t, []int{1, 0}, priorities(), "the local zone goes first after the retry")
+}
+
+func TestLocalityServiceConfig(t *testing.T) {
+	js := localityServiceConfig([]endpoint{
+		{Addr: "a1", Datacenter: "dc1", NodeMeta: map[string]string{"zone": "a"}},
+		{Addr: "a2", Datacenter: "dc1", NodeMeta: map[string]string{"zone": "a"}},
+		{Addr: "b1", Datacenter: "dc1", NodeMeta: map[string]string{"zone": "b"}, Priority: 1},
+		{Addr: "c1", Datacenter: "dc1", NodeMeta: map[string]string{"zone": "c"}, Priority: 1},
+		{Addr: "r1", Datacenter: "dc2", Priority: 2},
+	}, "zone")
+	var sc struct {
+		LoadBalancingConfig []map[string]json.RawMessage `json:"loadBalancingConfig"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(js), &sc))
+	require.Len(t, sc.LoadBalancingConfig, 1)
+
+	cfg, err := balancer.Get(LocalityBalancerName).(balancer.ConfigParser).ParseConfig(sc.LoadBalancingConfig[0][LocalityBalancerName])
+	require.NoError(t, err)
+	lc := cfg.(*localityConfig)
+	require.Equal(t, []string{"priority-0", "priority-1", "priority-2"}, lc.Priorities)
+	require.Equal(t, map[string]localityChild{
+		"priority-0": {Targets: map[string]splitTarget{"dc1/a": {Weight: 2}}},
+		"priority-1": {Targets: map[string]splitTarget{"dc1/b": {Weight: 1}, "dc1/c": {Weight: 1}}},
+		"priority-2": {Targets: map[string]splitTarget{"dc2/": {Weight: 1}}},
+	}, lc.Children)
+}
+
+func TestLocalityPicker(t *testing.T) {
+	b := &localityBalancer{
+		priorities: []string{"priority-0", "priority-1"},
+		weights: map[string]map[string]uint32{
+			"priority-0": {"dc1/a": 2},
+			"priority-1": {"dc1/b": 1, "dc1/c": 1},
+		},
+		paths: map[string][]string{
+			"a1": {"priority-0", "dc1/a"},
+			"a2": {"priority-0", "dc1/a"},
+			"b1": {"priority-1", "dc1/b"},
+			"c1": {"priority-1", "dc1/c"},
+		},
+	}
+	ready := func(addrs ...string) base.PickerBuildInfo {
+		info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+		for _, a := range addrs {
+			info.ReadySCs[&fakeSubConn{addr: a}] = base.SubConnInfo{Address: resolver.Address{Addr: a}}
+		}
+		return info
+	}
+	pick := func(p balancer.Picker, n int) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < n; i++ {
+			res, err := p.Pick(balancer.PickInfo{})
+			require.NoError(t, err)
+			counts[res.SubConn.(*fakeSubConn).addr]++
+		}
+		return counts
+	}
+
+	require.Equal(t, map[string]int{"a1": 5, "a2": 5}, pick(b.Build(ready("a1", "a2", "b1", "c1")), 10),
+		"the first priority gets all RPCs round-robin")
+
+	counts := pick(b.Build(ready("b1", "c1")), 10000)
+	require.InDelta(t, 0.5, float64(counts["b1"])/10000, 0.05, "failover to the next priority")
+	require.Equal(t, 10000, counts["b1"]+counts["c1"])
+
+	b.draining = map[string]bool{"a1": true, "a2": true}
+	require.Zero(t, pick(b.Build(ready("a1", "a2", "b1")), 10)["a1"], "draining addresses get no new RPCs")
+
+	_, err := b.Build(ready("x1")).Pick(balancer.PickInfo{})
+	require.ErrorIs(t, err, balancer.ErrNoSubConnAvailable)
+}
@@ -0,0 +1,101 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc/grpclog"
+)
+
+// Level is the importance of a log message.
+// Values are the same as in log/slog.
+type Level int
+
+// Supported log levels
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// parseLevel parses the 'log-level' URL parameter
+func parseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level '%s'", s)
+}
+
+// Logger is a structured logger for the resolver messages.
+// keyvals are alternating keys and values like in log/slog.
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// NewSlogLogger adapts *slog.Logger to the Logger interface
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	s.l.Log(context.Background(), slog.Level(level), msg, keyvals...)
+}
+
+// NewGrpcLogger returns the Logger which writes to grpclog.
+// It's used by default.
+func NewGrpcLogger() Logger {
+	return grpcLogger{}
+}
+
+type grpcLogger struct{}
+
+func (grpcLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	var sb strings.Builder
+	sb.WriteString("[Consul resolver] ")
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		sb.WriteString(" ")
+		if i+1 == len(keyvals) {
+			fmt.Fprintf(&sb, "!BADKEY={%v}", keyvals[i])
+			break
+		}
+		fmt.Fprintf(&sb, "%v={%v}", keyvals[i], keyvals[i+1])
+	}
+	switch {
+	case level >= LevelError:
+		grpclog.Error(sb.String())
+	case level >= LevelWarn:
+		grpclog.Warning(sb.String())
+	case level >= LevelInfo:
+		grpclog.Info(sb.String())
+	case grpclog.V(2):
+		grpclog.Info(sb.String())
+	}
+}
+
+// leveledLogger drops messages below the minimal level
+type leveledLogger struct {
+	Logger
+	min Level
+}
+
+func (l leveledLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.min {
+		return
+	}
+	l.Logger.Log(level, msg, keyvals...)
+}
@@ -0,0 +1,50 @@
+package consul
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeveledSlogLogger(t *testing.T) {
+	tests := []struct {
+		name  string
+		min   Level
+		level Level
+		want  string
+	}{
+		{"debug-dropped", LevelInfo, LevelDebug, ""},
+		{"info", LevelInfo, LevelInfo, "level=INFO msg=\"Endpoints updated\" service=svc added=[127.0.0.1:1024]\n"},
+		{"debug", LevelDebug, LevelDebug, "level=DEBUG msg=\"Endpoints updated\" service=svc added=[127.0.0.1:1024]\n"},
+		{"info-dropped", LevelError, LevelWarn, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+				Level: slog.LevelDebug,
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			})
+			l := leveledLogger{Logger: NewSlogLogger(slog.New(h)), min: tt.min}
+			l.Log(tt.level, "Endpoints updated", "service", "svc", "added", []string{"127.0.0.1:1024"})
+			require.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func Test_parseLevel(t *testing.T) {
+	for in, want := range map[string]Level{"debug": LevelDebug, "INFO": LevelInfo, "warn": LevelWarn, "error": LevelError} {
+		got, err := parseLevel(in)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+	_, err := parseLevel("verbose")
+	require.Error(t, err)
+}
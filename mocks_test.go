@@ -91,3 +91,81 @@ func (mock *servicerMock) ServiceCalls() []struct {
 	mock.lockService.RUnlock()
 	return calls
 }
+
+// Ensure, that catalogerMock does implement cataloger.
+// If this is not the case, regenerate this file with moq.
+var _ cataloger = &catalogerMock{}
+
+// catalogerMock is a mock implementation of cataloger.
+//
+//	func TestSomethingThatUsescataloger(t *testing.T) {
+//
+//		// make and configure a mocked cataloger
+//		mockedcataloger := &catalogerMock{
+//			ServiceFunc: func(s1 string, s2 string, queryOptions *api.QueryOptions) ([]*api.CatalogService, *api.QueryMeta, error) {
+//				panic("mock out the Service method")
+//			},
+//		}
+//
+//		// use mockedcataloger in code that requires cataloger
+//		// and then make assertions.
+//
+//	}
+type catalogerMock struct {
+	// ServiceFunc mocks the Service method.
+	ServiceFunc func(s1 string, s2 string, queryOptions *api.QueryOptions) ([]*api.CatalogService, *api.QueryMeta, error)
+
+	// calls tracks calls to the methods.
+	calls struct {
+		// Service holds details about calls to the Service method.
+		Service []struct {
+			// S1 is the s1 argument value.
+			S1 string
+			// S2 is the s2 argument value.
+			S2 string
+			// QueryOptions is the queryOptions argument value.
+			QueryOptions *api.QueryOptions
+		}
+	}
+	lockService sync.RWMutex
+}
+
+// Service calls ServiceFunc.
+func (mock *catalogerMock) Service(s1 string, s2 string, queryOptions *api.QueryOptions) ([]*api.CatalogService, *api.QueryMeta, error) {
+	if mock.ServiceFunc == nil {
+		panic("catalogerMock.ServiceFunc: method is nil but cataloger.Service was just called")
+	}
+	callInfo := struct {
+		S1           string
+		S2           string
+		QueryOptions *api.QueryOptions
+	}{
+		S1:           s1,
+		S2:           s2,
+		QueryOptions: queryOptions,
+	}
+	mock.lockService.Lock()
+	mock.calls.Service = append(mock.calls.Service, callInfo)
+	mock.lockService.Unlock()
+	return mock.ServiceFunc(s1, s2, queryOptions)
+}
+
+// ServiceCalls gets all the calls that were made to Service.
+// Check the length with:
+//
+//	len(mockedcataloger.ServiceCalls())
+func (mock *catalogerMock) ServiceCalls() []struct {
+	S1           string
+	S2           string
+	QueryOptions *api.QueryOptions
+} {
+	var calls []struct {
+		S1           string
+		S2           string
+		QueryOptions *api.QueryOptions
+	}
+	mock.lockService.RLock()
+	calls = mock.calls.Service
+	mock.lockService.RUnlock()
+	return calls
+}
@@ -0,0 +1,170 @@
+package consul
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/resolver"
+)
+
+// Option configures the builder returned by NewBuilder
+type Option func(*builder)
+
+// WithScheme registers the builder under the custom scheme instead of 'consul'
+func WithScheme(scheme string) Option {
+	return func(b *builder) {
+		b.scheme = scheme
+	}
+}
+
+// WithConsulConfig sets the base config for the Consul client.
+// Address, credentials, token and other settings from the target URL override it.
+func WithConsulConfig(cfg *api.Config) Option {
+	return func(b *builder) {
+		b.consulConfig = cfg
+	}
+}
+
+// WithConsulClient makes all resolvers use the ready client.
+// Address, credentials and other Consul API settings from the target URL are ignored then.
+func WithConsulClient(cli *api.Client) Option {
+	return func(b *builder) {
+		b.consulClient = func(resolver.Target) (*api.Client, error) {
+			return cli, nil
+		}
+	}
+}
+
+// WithConsulClientFactory makes resolvers use the client returned by f for their target.
+// Address, credentials and other Consul API settings from the target URL are ignored then.
+func WithConsulClientFactory(f func(resolver.Target) (*api.Client, error)) Option {
+	return func(b *builder) {
+		b.consulClient = f
+	}
+}
+
+// WithTag selects endpoints only with this tag unless 'tag' is in the URL
+func WithTag(tag string) Option {
+	return func(b *builder) {
+		b.defaults.Tag = tag
+	}
+}
+
+// WithHealthy returns only endpoints which pass all health-checks unless 'healthy' is in the URL
+func WithHealthy(healthy bool) Option {
+	return func(b *builder) {
+		b.defaults.Healthy = healthy
+	}
+}
+
+// WithWait sets the wait time for the blocking queries unless 'wait' is in the URL
+func WithWait(wait time.Duration) Option {
+	return func(b *builder) {
+		b.defaults.Wait = wait
+	}
+}
+
+// WithMaxBackoff sets the max backoff between reconnects to Consul unless 'max-backoff' is in the URL
+func WithMaxBackoff(d time.Duration) Option {
+	return func(b *builder) {
+		b.defaults.MaxBackoff = d
+	}
+}
+
+// WithStrict rejects unknown URL parameters and contradictory settings unless 'strict' is in the URL
+func WithStrict(strict bool) Option {
+	return func(b *builder) {
+		b.defaults.Strict = strict
+	}
+}
+
+// WithLimit limits number of the endpoints unless 'limit' is in the URL
+func WithLimit(limit int) Option {
+	return func(b *builder) {
+		b.defaults.Limit = limit
+	}
+}
+
+// WithSubset makes every client use only n endpoints selected by the deterministic subsetting
+// unless 'subset' is in the URL
+func WithSubset(n int) Option {
+	return func(b *builder) {
+		b.defaults.Subset = n
+	}
+}
+
+// WithClientID sets the identity of the client for the subsetting unless 'client-id' is in the URL.
+// Default: POD_NAME environment variable or the hostname.
+func WithClientID(id string) Option {
+	return func(b *builder) {
+		b.defaults.ClientID = id
+	}
+}
+
+// WithPreferZone prefers endpoints in the zone unless 'prefer-zone' is in the URL.
+// Zone '_agent' is the zone of the local Consul agent.
+func WithPreferZone(zone string) Option {
+	return func(b *builder) {
+		b.defaults.PreferZone = zone
+	}
+}
+
+// WithDatacenter sets the Consul datacenter unless 'dc' is in the URL
+func WithDatacenter(dc string) Option {
+	return func(b *builder) {
+		b.defaults.Dc = dc
+	}
+}
+
+// WithLogLevel sets the minimal level of the log messages unless 'log-level' is in the URL
+func WithLogLevel(level Level) Option {
+	return func(b *builder) {
+		b.defaults.LogLevel = level
+	}
+}
+
+// WithFilter skips service entries for which f returns false
+func WithFilter(f func(*api.ServiceEntry) bool) Option {
+	return func(b *builder) {
+		b.filter = f
+	}
+}
+
+// WithUpdateHook registers h to be called after every update which changed the endpoints.
+// Hooks are called synchronously from the resolver goroutine so they should be fast.
+func WithUpdateHook(h UpdateHook) Option {
+	return func(b *builder) {
+		b.hooks = append(b.hooks, h)
+	}
+}
+
+// WithUpdateChannel sends every update which changed the endpoints to ch.
+// The resolver never blocks on ch: updates are dropped when it's full.
+func WithUpdateChannel(ch chan<- EndpointsUpdate) Option {
+	return func(b *builder) {
+		b.updateChans = append(b.updateChans, ch)
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing of the Consul lookups with the given provider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(b *builder) {
+		b.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider enables OpenTelemetry metrics of the Consul lookups with the given provider
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(b *builder) {
+		b.meterProvider = mp
+	}
+}
+
+// WithLogger sets the logger for the resolver messages. Default: grpclog.
+func WithLogger(l Logger) Option {
+	return func(b *builder) {
+		b.logger = l
+	}
+}
@@ -0,0 +1,66 @@
+package consul
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Values of the 'order' URL parameter
+const (
+	// orderLexical sorts addresses lexically so the same endpoints always give the same list
+	orderLexical = "lexical"
+	// orderNear keeps the order of Consul which sorts endpoints by RTT with 'near'
+	orderNear = "near"
+	// orderShuffle shuffles endpoints randomly
+	orderShuffle = "shuffle"
+	// orderRendezvous shuffles endpoints deterministically for the client by rendezvous hashing
+	orderRendezvous = "rendezvous"
+)
+
+// orderEndpoints orders the deduplicated endpoints for the 'near', 'shuffle' and 'rendezvous' modes.
+// In the 'rendezvous' mode every client has its own order which depends only on the client ID
+// and the addresses, so new endpoints don't move others and the first address differs between clients.
+// Other modes keep the previous order while the set of endpoints is the same,
+// so RTT jitter or a forced refresh doesn't reorder the list for pick_first.
+func orderEndpoints(ee []endpoint, mode, clientID string, prev []string) []endpoint {
+	if mode == orderRendezvous {
+		scores := make(map[string]uint64, len(ee))
+		for _, e := range ee {
+			scores[e.Addr] = rendezvousScore(clientID, e.Addr)
+		}
+		sort.SliceStable(ee, func(i, j int) bool { return scores[ee[i].Addr] > scores[ee[j].Addr] })
+		return ee
+	}
+	if samePrimaryAddrs(ee, prev) {
+		pos := make(map[string]int, len(prev))
+		for i, a := range prev {
+			pos[a] = i
+		}
+		res := make([]endpoint, len(ee))
+		for _, e := range ee {
+			res[pos[e.Addr]] = e
+		}
+		return res
+	}
+	if mode == orderShuffle {
+		rand.Shuffle(len(ee), func(i, j int) { ee[i], ee[j] = ee[j], ee[i] })
+	}
+	return ee
+}
+
+// samePrimaryAddrs is true when the endpoints have exactly the given addresses
+func samePrimaryAddrs(ee []endpoint, addrs []string) bool {
+	if len(ee) != len(addrs) {
+		return false
+	}
+	set := make(map[string]struct{}, len(addrs))
+	for _, a := range addrs {
+		set[a] = struct{}{}
+	}
+	for _, e := range ee {
+		if _, ok := set[e.Addr]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestOrderEndpoints(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		prev  []string
+		want  []string
+	}{
+		{"first", []string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80"}, nil, []string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80"}},
+		{"jitter", []string{"10.0.0.1:80", "10.0.0.3:80", "10.0.0.2:80"},
+			[]string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80"},
+			[]string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80"},
+		},
+		{"added", []string{"10.0.0.4:80", "10.0.0.1:80", "10.0.0.3:80", "10.0.0.2:80"},
+			[]string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80"},
+			[]string{"10.0.0.4:80", "10.0.0.1:80", "10.0.0.3:80", "10.0.0.2:80"},
+		},
+		{"removed", []string{"10.0.0.1:80", "10.0.0.2:80"},
+			[]string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80"},
+			[]string{"10.0.0.1:80", "10.0.0.2:80"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, addrsOf(orderEndpoints(endpointsOf(tt.input...), orderNear, "", tt.prev)))
+		})
+	}
+}
+
+func TestOrderShuffle(t *testing.T) {
+	in := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80", "10.0.0.5:80", "10.0.0.6:80"}
+	got := addrsOf(orderEndpoints(endpointsOf(in...), orderShuffle, "", nil))
+	require.ElementsMatch(t, in, got)
+	require.Equal(t, got, addrsOf(orderEndpoints(endpointsOf(in...), orderShuffle, "", got)), "same set keeps the order")
+}
+
+func TestOrderRendezvous(t *testing.T) {
+	in := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80", "10.0.0.5:80", "10.0.0.6:80"}
+	got := addrsOf(orderEndpoints(endpointsOf(in...), orderRendezvous, "pod-1", nil))
+	require.ElementsMatch(t, in, got)
+
+	reversed := make([]string, 0, len(in))
+	for i := len(in) - 1; i >= 0; i-- {
+		reversed = append(reversed, in[i])
+	}
+	require.Equal(t, got, addrsOf(orderEndpoints(endpointsOf(reversed...), orderRendezvous, "pod-1", nil)),
+		"order depends only on the client and the addresses")
+
+	added := addrsOf(orderEndpoints(endpointsOf(append(in, "10.0.0.7:80")...), orderRendezvous, "pod-1", nil))
+	var withoutNew []string
+	for _, a := range added {
+		if a != "10.0.0.7:80" {
+			withoutNew = append(withoutNew, a)
+		}
+	}
+	require.Equal(t, got, withoutNew, "new endpoint doesn't move others")
+
+	first := map[string]int{}
+	for i := 0; i < 600; i++ {
+		ee := orderEndpoints(endpointsOf(in...), orderRendezvous, fmt.Sprintf("pod-%d", i), nil)
+		first[ee[0].Addr]++
+	}
+	require.Len(t, first, len(in), "clients start from different endpoints")
+	for addr, n := range first {
+		require.InDelta(t, 100, n, 40, addr)
+	}
+}
+
+func TestPopulateEndpointsOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order string
+		want  []string
+	}{
+		{"lexical", "", []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}},
+		{"near", orderNear, []string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan []string, 2)
+			fcc := &ClientConnMock{
+				UpdateStateFunc: func(state resolver.State) error {
+					addrs := make([]string, 0, len(state.Addresses))
+					for _, a := range state.Addresses {
+						addrs = append(addrs, a.Addr)
+					}
+					got <- addrs
+					return nil
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			in := make(chan update)
+			r := newTestResolvr(t, target{Order: tt.order})
+			go r.populateEndpoints(ctx, fcc, in)
+
+			in <- update{span: tracenoop.Span{}, endpoints: endpointsOf("10.0.0.3:80", "10.0.0.1:80", "10.0.0.1:80", "10.0.0.2:80")}
+			require.Equal(t, tt.want, <-got)
+			// RTT jitter
+			in <- update{span: tracenoop.Span{}, endpoints: endpointsOf("10.0.0.1:80", "10.0.0.3:80", "10.0.0.2:80")}
+			require.Equal(t, tt.want, <-got)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package consul
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// serverNameData is available in the 'server-name' template.
+// Fields of the endpoint like .Node, .ID, .Datacenter and .Meta are promoted.
+type serverNameData struct {
+	Service string
+	endpoint
+}
+
+func parseServerName(s string) (*template.Template, error) {
+	tmpl, err := template.New("server-name").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "Malformed server-name template")
+	}
+	return tmpl, nil
+}
+
+// serverName returns the TLS server name of the endpoint from the 'server-name-meta'
+// service meta or the 'server-name' template. Empty name keeps the default from the target.
+func (r *resolvr) serverName(e endpoint) string {
+	if len(r.tgt.ServerNameMeta) > 0 {
+		if name := e.Meta[r.tgt.ServerNameMeta]; len(name) > 0 {
+			return name
+		}
+	}
+	if r.tgt.serverNameTmpl == nil {
+		return ""
+	}
+	service := r.tgt.Service
+	if len(e.Service) > 0 {
+		service = e.Service
+	}
+	var sb strings.Builder
+	if err := r.tgt.serverNameTmpl.Execute(&sb, serverNameData{Service: service, endpoint: e}); err != nil {
+		r.log.Log(LevelWarn, "Couldn't render server name", r.tgt.logFields("addr", e.Addr, "error", err)...)
+		return ""
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package consul
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerName(t *testing.T) {
+	e := endpoint{
+		Addr:       "10.0.0.1:8080",
+		Node:       "node-1",
+		ID:         "svc-1",
+		Datacenter: "dc1",
+		Meta:       map[string]string{"tls_name": "svc-1.internal"},
+	}
+	tests := []struct {
+		name string
+		tgt  target
+		want string
+	}{
+		{"none", target{Service: "svc"}, ""},
+		{"meta", target{Service: "svc", ServerNameMeta: "tls_name"}, "svc-1.internal"},
+		{"template", target{Service: "svc", ServerName: "{{.Service}}.{{.Node}}.{{.Datacenter}}.example.com"}, "svc.node-1.dc1.example.com"},
+		{"meta-missing-template", target{Service: "svc", ServerNameMeta: "cert", ServerName: "{{.ID}}.example.com"}, "svc-1.example.com"},
+		{"template-meta", target{Service: "svc", ServerName: `{{index .Meta "tls_name"}}`}, "svc-1.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.tgt.ServerName) > 0 {
+				tmpl, err := parseServerName(tt.tgt.ServerName)
+				require.NoError(t, err)
+				tt.tgt.serverNameTmpl = tmpl
+			}
+			r := newTestResolvr(t, tt.tgt)
+			require.Equal(t, tt.want, r.address(e).ServerName)
+		})
+	}
+
+	_, err := parseServerName("{{.Node")
+	require.Error(t, err)
+
+	tgt, err := parseURL("consul://127.0.0.1:8500/svc?server-name={{.Node}}.example.com", target{})
+	require.NoError(t, err)
+	require.NotNil(t, tgt.serverNameTmpl, "the template is parsed once with the URL")
+	_, err = parseURL("consul://127.0.0.1:8500/svc?server-name={{.Node", target{})
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, "server-name", perr.Field)
+}
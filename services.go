@@ -0,0 +1,170 @@
+package consul
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// serviceSpec is a single service of the multi-service target path.
+// Format of the path: 'name[;tag=tag][;weight=N][,name...]'
+type serviceSpec struct {
+	Name   string `json:"name"`
+	Tag    string `json:"tag,omitempty"`
+	Weight int    `json:"weight"`
+}
+
+// parseServices parses the services of the target path.
+// Empty tag of the service means the 'tag' parameter of the target, weight defaults to 1.
+// weighted is true when any of the services has the weight.
+func parseServices(path string) (specs []serviceSpec, weighted bool, err error) {
+	seen := map[string]bool{}
+	for _, item := range strings.Split(path, ",") {
+		parts := strings.Split(item, ";")
+		spec := serviceSpec{Name: parts[0], Weight: 1}
+		if len(spec.Name) == 0 {
+			return nil, false, parseErrorf("service", "empty service name in '%s'", path)
+		}
+		for _, p := range parts[1:] {
+			k, v, _ := strings.Cut(p, "=")
+			switch k {
+			case "tag":
+				spec.Tag = v
+			case "weight":
+				w, err := strconv.Atoi(v)
+				if err != nil || w <= 0 {
+					return nil, false, parseErrorf("service", "weight of '%s' must be a positive integer", spec.Name)
+				}
+				spec.Weight = w
+				weighted = true
+			default:
+				return nil, false, parseErrorf("service", "unknown option '%s' of '%s'", k, spec.Name)
+			}
+		}
+		key := spec.Name + ";" + spec.Tag
+		if seen[key] {
+			return nil, false, parseErrorf("service", "duplicate service '%s'", spec.Name)
+		}
+		seen[key] = true
+		specs = append(specs, spec)
+	}
+	return specs, weighted, nil
+}
+
+// servicesSplit returns the split groups by the weights of the services.
+// Traffic is split by the consul_split balancer like with the 'split' parameter.
+func servicesSplit(specs []serviceSpec) ([]splitGroup, error) {
+	groups := make([]splitGroup, 0, len(specs))
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		if seen[spec.Name] {
+			return nil, parseErrorf("service", "weights require distinct services, '%s' is listed twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		groups = append(groups, splitGroup{Name: spec.Name, Weight: spec.Weight})
+	}
+	return groups, nil
+}
+
+// forService returns the target which watches only the single service of the list
+func (t target) forService(spec serviceSpec) target {
+	t.Service = spec.Name
+	if len(spec.Tag) > 0 {
+		t.Tag = spec.Tag
+	}
+	t.Services = nil
+	// Subset and limit are applied to the merged endpoints
+	t.Subset, t.Limit = 0, 0
+	return t
+}
+
+// mergeServices combines the latest endpoints of the per-service watches into a single update.
+// Endpoints are annotated with their service; the earlier service of the list wins
+// when the same address is registered in several services.
+// 'subset' and 'limit' of the target are applied to the merged endpoints.
+func (r *resolvr) mergeServices(ctx context.Context, ins []<-chan update, out chan<- update) {
+	specs := r.tgt.Services
+	type serviceUpdate struct {
+		i int
+		u update
+	}
+	all := make(chan serviceUpdate)
+	for i, in := range ins {
+		go func(i int, in <-chan update) {
+			for {
+				select {
+				case u := <-in:
+					select {
+					case all <- serviceUpdate{i: i, u: u}:
+					case <-ctx.Done():
+						u.span.End()
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, in)
+	}
+
+	latest := make([][]endpoint, len(ins))
+	for {
+		select {
+		case su := <-all:
+			spec := specs[su.i]
+			latest[su.i] = make([]endpoint, 0, len(su.u.endpoints))
+			for _, e := range su.u.endpoints {
+				e.Service, e.Weight = spec.Name, spec.Weight
+				latest[su.i] = append(latest[su.i], e)
+			}
+			var ee []endpoint
+			seen := map[string]bool{}
+			for _, l := range latest {
+				for _, e := range l {
+					if !seen[e.Addr] {
+						seen[e.Addr] = true
+						ee = append(ee, e)
+					}
+				}
+			}
+			ee = rendezvousSubset(ee, r.tgt.Subset, r.tgt.ClientID)
+			if r.tgt.Limit != 0 && len(ee) > r.tgt.Limit {
+				ee = ee[:r.tgt.Limit]
+			}
+			select {
+			case out <- update{span: su.u.span, endpoints: ee}:
+			case <-ctx.Done():
+				su.u.span.End()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SourceService is the service of the multi-service target which the address belongs to.
+// It's attached to every address when the target path has several services.
+type SourceService struct {
+	Name   string
+	Weight int
+}
+
+type sourceServiceKey struct{}
+
+// SourceServiceFromAddress returns the service attached to the address by the resolver
+func SourceServiceFromAddress(addr resolver.Address) (SourceService, bool) {
+	s, ok := addr.BalancerAttributes.Value(sourceServiceKey{}).(SourceService)
+	return s, ok
+}
+
+// setSourceService attaches the service of the endpoint to the address
+func setSourceService(addr resolver.Address, e endpoint) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(sourceServiceKey{}, SourceService{
+		Name:   e.Service,
+		Weight: e.Weight,
+	})
+	return addr
+}
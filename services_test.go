@@ -0,0 +1,162 @@
+package consul
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+func TestParseServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		want     []serviceSpec
+		weighted bool
+		err      bool
+	}{
+		{"plain", "orders-v1,orders-v2",
+			[]serviceSpec{{Name: "orders-v1", Weight: 1}, {Name: "orders-v2", Weight: 1}},
+			false, false,
+		},
+		{"overrides", "orders-v1;weight=9,orders-v2;tag=canary;weight=1",
+			[]serviceSpec{{Name: "orders-v1", Weight: 9}, {Name: "orders-v2", Tag: "canary", Weight: 1}},
+			true, false,
+		},
+		{"same-service-other-tag", "orders;tag=blue,orders;tag=green",
+			[]serviceSpec{{Name: "orders", Tag: "blue", Weight: 1}, {Name: "orders", Tag: "green", Weight: 1}},
+			false, false,
+		},
+		{"empty-name", "orders-v1,", nil, false, true},
+		{"zero-weight", "orders-v1;weight=0,orders-v2", nil, false, true},
+		{"bad-weight", "orders-v1;weight=a,orders-v2", nil, false, true},
+		{"unknown-option", "orders-v1;dc=dc2,orders-v2", nil, false, true},
+		{"duplicate", "orders-v1,orders-v1", nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, weighted, err := parseServices(tt.path)
+			require.Equal(t, tt.err, err != nil, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.weighted, weighted)
+		})
+	}
+}
+
+func TestParseURLServiceWeights(t *testing.T) {
+	tgt, err := parseURL("consul://127.0.0.1:8500/orders-v1;weight=9,orders-v2", target{})
+	require.NoError(t, err)
+	require.Equal(t, []splitGroup{{Name: "orders-v1", Weight: 9}, {Name: "orders-v2", Weight: 1}}, tgt.SplitGroups,
+		"weights of the services split traffic")
+
+	tgt, err = parseURL("consul://127.0.0.1:8500/orders-v1,orders-v2", target{})
+	require.NoError(t, err)
+	require.Empty(t, tgt.SplitGroups)
+
+	_, err = parseURL("consul://127.0.0.1:8500/orders-v1;weight=9,orders-v2?split=orders-v1:1", target{})
+	require.Error(t, err)
+	_, err = parseURL("consul://127.0.0.1:8500/orders;tag=blue;weight=9,orders;tag=green", target{})
+	require.Error(t, err)
+}
+
+func TestWatchMultipleServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	entries := map[string][]*api.ServiceEntry{
+		"orders-v1/":       {{Service: &api.AgentService{Address: "10.0.0.1", Port: 80}}, {Service: &api.AgentService{Address: "10.0.0.2", Port: 80}}},
+		"orders-v2/canary": {{Service: &api.AgentService{Address: "10.0.0.2", Port: 80}}, {Service: &api.AgentService{Address: "10.0.0.3", Port: 80}}},
+	}
+	fconsul := &servicerMock{
+		ServiceFunc: func(service, tag string, _ bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+			if q.WaitIndex > 0 {
+				<-ctx.Done()
+			}
+			return entries[service+"/"+tag], &api.QueryMeta{LastIndex: 1}, nil
+		},
+	}
+	tgt, err := parseURL("consul://127.0.0.1:8500/orders-v1;weight=9,orders-v2;tag=canary", target{})
+	require.NoError(t, err)
+	r := newTestResolvr(t, tgt)
+	out := make(chan update)
+	go r.watchConsulService(ctx, fconsul, out)
+
+	var got []endpoint
+	for len(got) < 3 {
+		select {
+		case u := <-out:
+			got = u.endpoints
+		case <-time.After(time.Second):
+			t.Fatal("no merged endpoints")
+		}
+	}
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}, addrsOf(got))
+
+	states := make(chan resolver.State, 1)
+	fcc := &ClientConnMock{
+		ParseServiceConfigFunc: func(js string) *serviceconfig.ParseResult {
+			return &serviceconfig.ParseResult{Config: struct{ serviceconfig.Config }{}}
+		},
+		UpdateStateFunc: func(state resolver.State) error {
+			states <- state
+			return nil
+		},
+	}
+	in := make(chan update, 1)
+	go r.populateEndpoints(ctx, fcc, in)
+	in <- update{span: tracenoop.Span{}, endpoints: got}
+	state := <-states
+	require.NotNil(t, state.ServiceConfig, "weights of the services select the split balancer")
+	require.Equal(t, splitServiceConfig(tgt.SplitGroups), fcc.ParseServiceConfigCalls()[0].ServiceConfigJSON)
+
+	want := map[string]SourceService{
+		"10.0.0.1:80": {Name: "orders-v1", Weight: 9},
+		"10.0.0.2:80": {Name: "orders-v1", Weight: 9}, // the earlier service wins
+		"10.0.0.3:80": {Name: "orders-v2", Weight: 1},
+	}
+	require.Len(t, state.Addresses, len(want))
+	for _, addr := range state.Addresses {
+		s, ok := SourceServiceFromAddress(addr)
+		require.True(t, ok)
+		require.Equal(t, want[addr.Addr], s, addr.Addr)
+	}
+}
+
+func TestMergeServicesSubsetAndLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fconsul := &servicerMock{
+		ServiceFunc: func(service, _ string, _ bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+			if q.WaitIndex > 0 {
+				<-ctx.Done()
+			}
+			ss := []*api.ServiceEntry{{Service: &api.AgentService{Address: "10.0.0.1", Port: 80}}}
+			if service == "b" {
+				ss = append(ss, &api.ServiceEntry{Service: &api.AgentService{Address: "10.0.0.2", Port: 80}})
+			}
+			return ss, &api.QueryMeta{LastIndex: 1}, nil
+		},
+	}
+	tgt, err := parseURL("consul://127.0.0.1:8500/a,b?limit=2", target{})
+	require.NoError(t, err)
+	r := newTestResolvr(t, tgt)
+	out := make(chan update)
+	go r.watchConsulService(ctx, fconsul, out)
+
+	var got []endpoint
+	for len(got) < 2 {
+		select {
+		case u := <-out:
+			got = u.endpoints
+		case <-time.After(time.Second):
+			t.Fatal("no merged endpoints")
+		}
+	}
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, addrsOf(got), "limit is applied to the deduplicated endpoints")
+}
@@ -0,0 +1,200 @@
+package consul
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// SplitBalancerName is the name of the balancer which splits RPCs between the groups of the 'split' parameter.
+// The resolver selects it in the service config of the split targets.
+const SplitBalancerName = "consul_split"
+
+func init() {
+	balancer.Register(splitBuilder{})
+}
+
+// splitGroup is a single group of the 'split' parameter: 'name:weight'.
+// Endpoints belong to the group by the tag or by the service of the multi-service target.
+type splitGroup struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// parseSplit parses the 'split' parameter like 'stable:95,canary:5'
+func parseSplit(s string) ([]splitGroup, error) {
+	var groups []splitGroup
+	total := 0
+	seen := map[string]bool{}
+	for _, item := range strings.Split(s, ",") {
+		name, weight, _ := strings.Cut(item, ":")
+		w, err := strconv.Atoi(weight)
+		if len(name) == 0 || err != nil || w < 0 {
+			return nil, parseErrorf("split", "group '%s' must be 'name:weight'", item)
+		}
+		if seen[name] {
+			return nil, parseErrorf("split", "duplicate group '%s'", name)
+		}
+		seen[name] = true
+		groups = append(groups, splitGroup{Name: name, Weight: w})
+		total += w
+	}
+	if total == 0 {
+		return nil, parseErrorf("split", "'%s' has no weight", s)
+	}
+	return groups, nil
+}
+
+// splitGroupOf returns the first group of the list which the endpoint belongs to
+func splitGroupOf(e endpoint, groups []splitGroup) (string, bool) {
+	for _, g := range groups {
+		if g.Name == e.Service || hasTag(e.Tags, g.Name) {
+			return g.Name, true
+		}
+	}
+	return "", false
+}
+
+// splitServiceConfig returns the service config of the split target in the weighted_target style
+func splitServiceConfig(groups []splitGroup) string {
+	cfg := splitConfig{Targets: make(map[string]splitTarget, len(groups))}
+	for _, g := range groups {
+		cfg.Targets[g.Name] = splitTarget{Weight: uint32(g.Weight)}
+	}
+	js, _ := json.Marshal(map[string]interface{}{
+		"loadBalancingConfig": []map[string]splitConfig{{SplitBalancerName: cfg}},
+	})
+	return string(js)
+}
+
+type splitGroupKey struct{}
+
+// SplitGroupFromAddress returns the group of the 'split' parameter attached to the address by the resolver
+func SplitGroupFromAddress(addr resolver.Address) (string, bool) {
+	g, ok := addr.BalancerAttributes.Value(splitGroupKey{}).(string)
+	return g, ok
+}
+
+// setSplitGroup attaches the split group to the address
+func setSplitGroup(addr resolver.Address, group string) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(splitGroupKey{}, group)
+	return addr
+}
+
+// splitConfig is the config of the split balancer
+type splitConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	Targets map[string]splitTarget `json:"targets"`
+}
+
+type splitTarget struct {
+	Weight uint32 `json:"weight"`
+}
+
+// splitBuilder builds the split balancer.
+// Every group gets the share of RPCs by its weight and RPCs are spread round-robin inside the group.
+// Shares of the groups without ready connections go to other groups. Draining addresses get no new RPCs.
+type splitBuilder struct{}
+
+func (splitBuilder) Name() string { return SplitBalancerName }
+
+func (splitBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	b := &splitBalancer{}
+	b.Balancer = base.NewBalancerBuilder(SplitBalancerName, b, base.Config{HealthCheck: true}).Build(cc, opts)
+	return b
+}
+
+func (splitBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var cfg splitConfig
+	if err := json.Unmarshal(js, &cfg); err != nil {
+		return nil, errors.Wrap(err, "Malformed split balancer config")
+	}
+	return &cfg, nil
+}
+
+// splitBalancer keeps the weights from the config and the groups of the addresses
+// for the picker which is built by the base balancer.
+type splitBalancer struct {
+	balancer.Balancer
+
+	mu       sync.Mutex
+	weights  map[string]uint32
+	groups   map[string]string // address -> group
+	draining map[string]bool
+}
+
+func (b *splitBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.mu.Lock()
+	b.weights = map[string]uint32{}
+	if cfg, ok := s.BalancerConfig.(*splitConfig); ok {
+		for name, t := range cfg.Targets {
+			b.weights[name] = t.Weight
+		}
+	}
+	b.groups = make(map[string]string, len(s.ResolverState.Addresses))
+	b.draining = map[string]bool{}
+	for _, addr := range s.ResolverState.Addresses {
+		if g, ok := SplitGroupFromAddress(addr); ok {
+			b.groups[addr.Addr] = g
+		}
+		if IsDraining(addr) {
+			b.draining[addr.Addr] = true
+		}
+	}
+	b.mu.Unlock()
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+// Build implements base.PickerBuilder
+func (b *splitBalancer) Build(info base.PickerBuildInfo) balancer.Picker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	byGroup := map[string][]balancer.SubConn{}
+	for sc, sci := range info.ReadySCs {
+		g := b.groups[sci.Address.Addr]
+		if b.weights[g] > 0 && !b.draining[sci.Address.Addr] {
+			byGroup[g] = append(byGroup[g], sc)
+		}
+	}
+	p := &splitPicker{}
+	for g, scs := range byGroup {
+		p.total += b.weights[g]
+		p.groups = append(p.groups, &splitPickerGroup{upTo: p.total, subConns: scs})
+	}
+	if len(p.groups) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	return p
+}
+
+type splitPicker struct {
+	total  uint32
+	groups []*splitPickerGroup
+}
+
+type splitPickerGroup struct {
+	upTo     uint32 // cumulative weight
+	subConns []balancer.SubConn
+	next     uint32
+}
+
+func (p *splitPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	w := uint32(rand.Int63n(int64(p.total)))
+	for _, g := range p.groups {
+		if w < g.upTo {
+			n := atomic.AddUint32(&g.next, 1)
+			return balancer.PickResult{SubConn: g.subConns[n%uint32(len(g.subConns))]}, nil
+		}
+	}
+	return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+}
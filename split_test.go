@@ -0,0 +1,113 @@
+package consul
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestParseSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		split string
+		want  []splitGroup
+		err   bool
+	}{
+		{"two", "stable:95,canary:5", []splitGroup{{Name: "stable", Weight: 95}, {Name: "canary", Weight: 5}}, false},
+		{"drained", "stable:100,canary:0", []splitGroup{{Name: "stable", Weight: 100}, {Name: "canary", Weight: 0}}, false},
+		{"no-weight", "stable", nil, true},
+		{"negative", "stable:100,canary:-5", nil, true},
+		{"duplicate", "stable:50,stable:50", nil, true},
+		{"zero-total", "stable:0,canary:0", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSplit(tt.split)
+			require.Equal(t, tt.err, err != nil, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSplitServiceConfig(t *testing.T) {
+	js := splitServiceConfig([]splitGroup{{Name: "stable", Weight: 95}, {Name: "canary", Weight: 5}})
+	var sc struct {
+		LoadBalancingConfig []map[string]json.RawMessage `json:"loadBalancingConfig"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(js), &sc))
+	require.Len(t, sc.LoadBalancingConfig, 1)
+
+	cfg, err := balancer.Get(SplitBalancerName).(balancer.ConfigParser).ParseConfig(sc.LoadBalancingConfig[0][SplitBalancerName])
+	require.NoError(t, err)
+	require.Equal(t, map[string]splitTarget{"stable": {Weight: 95}, "canary": {Weight: 5}}, cfg.(*splitConfig).Targets)
+}
+
+func TestSplitClientState(t *testing.T) {
+	tgt, err := parseURL("consul://127.0.0.1:8500/svc?split=stable:95,canary:5", target{})
+	require.NoError(t, err)
+	r := newTestResolvr(t, tgt)
+	state := r.clientState([]endpoint{
+		{Addr: "10.0.0.1:80", Tags: []string{"stable"}},
+		{Addr: "10.0.0.2:80", Tags: []string{"canary", "stable"}},
+		{Addr: "10.0.0.3:80", Tags: []string{"other"}},
+	})
+	got := map[string]string{}
+	for _, addr := range state.Addresses {
+		g, ok := SplitGroupFromAddress(addr)
+		require.True(t, ok)
+		got[addr.Addr] = g
+	}
+	require.Equal(t, map[string]string{"10.0.0.1:80": "stable", "10.0.0.2:80": "stable"}, got,
+		"endpoint belongs to the first group and endpoints out of groups are skipped")
+}
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func TestSplitPicker(t *testing.T) {
+	b := &splitBalancer{
+		weights: map[string]uint32{"stable": 95, "canary": 5, "drained": 0},
+		groups:  map[string]string{"s1": "stable", "s2": "stable", "c1": "canary", "d1": "drained"},
+	}
+	ready := func(addrs ...string) base.PickerBuildInfo {
+		info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+		for _, a := range addrs {
+			info.ReadySCs[&fakeSubConn{addr: a}] = base.SubConnInfo{Address: resolver.Address{Addr: a}}
+		}
+		return info
+	}
+
+	p := b.Build(ready("s1", "s2", "c1", "d1"))
+	counts := map[string]int{}
+	const picks = 20000
+	for i := 0; i < picks; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		counts[res.SubConn.(*fakeSubConn).addr]++
+	}
+	require.InDelta(t, 0.05, float64(counts["c1"])/picks, 0.01)
+	require.InDelta(t, counts["s1"], counts["s2"], 1, "round robin inside the group")
+	require.Zero(t, counts["d1"])
+
+	p = b.Build(ready("c1"))
+	res, err := p.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	require.Equal(t, "c1", res.SubConn.(*fakeSubConn).addr, "share of the group without ready connections goes to others")
+
+	_, err = b.Build(ready("d1")).Pick(balancer.PickInfo{})
+	require.ErrorIs(t, err, balancer.ErrNoSubConnAvailable)
+
+	b.draining = map[string]bool{"s2": true}
+	p = b.Build(ready("s1", "s2"))
+	for i := 0; i < 10; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		require.Equal(t, "s1", res.SubConn.(*fakeSubConn).addr, "draining addresses get no new RPCs")
+	}
+}
@@ -0,0 +1,63 @@
+package consul
+
+import (
+	"hash/fnv"
+	"os"
+	"sort"
+)
+
+// defaultClientID identifies this client for the subsetting.
+// It's the pod name in Kubernetes and the hostname otherwise.
+func defaultClientID() string {
+	if pod := os.Getenv("POD_NAME"); len(pod) > 0 {
+		return pod
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// rendezvousSubset deterministically selects n endpoints for the client with rendezvous hashing.
+// Every client gets its own subset, so the load is spread evenly across all endpoints,
+// and the subset changes only when one of its endpoints comes or goes.
+// Selected endpoints keep their order.
+func rendezvousSubset(ee []endpoint, n int, clientID string) []endpoint {
+	if n <= 0 || len(ee) <= n {
+		return ee
+	}
+	type scored struct {
+		idx   int
+		score uint64
+	}
+	ss := make([]scored, 0, len(ee))
+	for i, e := range ee {
+		ss = append(ss, scored{idx: i, score: rendezvousScore(clientID, e.Addr)})
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i].score > ss[j].score })
+	ss = ss[:n]
+	sort.Slice(ss, func(i, j int) bool { return ss[i].idx < ss[j].idx })
+
+	res := make([]endpoint, 0, n)
+	for _, s := range ss {
+		res = append(res, ee[s.idx])
+	}
+	return res
+}
+
+// rendezvousScore is the weight of the address for the client in the rendezvous hashing
+func rendezvousScore(clientID, addr string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(clientID))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(addr))
+	return mix64(h.Sum64())
+}
+
+// mix64 is the finalizer from MurmurHash3. FNV alone distributes similar inputs poorly.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,67 @@
+package consul
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rendezvousSubset(t *testing.T) {
+	var backends []string
+	for i := 0; i < 20; i++ {
+		backends = append(backends, fmt.Sprintf("10.0.0.%d:8080", i))
+	}
+	ee := endpointsOf(backends...)
+
+	require.Equal(t, ee, rendezvousSubset(ee, 0, "client"))
+	require.Equal(t, ee, rendezvousSubset(ee, 30, "client"))
+
+	got := rendezvousSubset(ee, 5, "client")
+	require.Len(t, got, 5)
+	require.Equal(t, got, rendezvousSubset(ee, 5, "client"), "must be deterministic")
+
+	// departed backend outside of the subset doesn't change it
+	for _, e := range ee {
+		if !contains(addrsOf(got), e.Addr) {
+			require.Equal(t, got, rendezvousSubset(removeAddr(ee, e.Addr), 5, "client"))
+			break
+		}
+	}
+
+	// departed backend in the subset is replaced by one new backend
+	replaced := rendezvousSubset(removeAddr(ee, got[0].Addr), 5, "client")
+	require.Len(t, replaced, 5)
+	require.Subset(t, addrsOf(replaced), addrsOf(got[1:]))
+
+	// load is spread evenly across the backends
+	load := map[string]int{}
+	for c := 0; c < 2000; c++ {
+		for _, e := range rendezvousSubset(ee, 5, fmt.Sprintf("client-%d", c)) {
+			load[e.Addr]++
+		}
+	}
+	require.Len(t, load, len(backends))
+	for addr, n := range load {
+		require.InDelta(t, 2000*5/len(backends), n, 100, addr)
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeAddr(ee []endpoint, addr string) []endpoint {
+	var res []endpoint
+	for _, e := range ee {
+		if e.Addr != addr {
+			res = append(res, e)
+		}
+	}
+	return res
+}
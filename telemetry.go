@@ -0,0 +1,127 @@
+package consul
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentationName is used for the tracer and the meter of this resolver
+const instrumentationName = "github.com/mbobakov/grpc-consul-resolver"
+
+// telemetry holds OpenTelemetry instruments for the Consul lookups.
+// With nil providers all instruments are no-op.
+type telemetry struct {
+	tracer  trace.Tracer
+	lookups metric.Int64Counter
+	errors  metric.Int64Counter
+	fetched metric.Int64Histogram
+	updates metric.Int64Counter
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) (*telemetry, error) {
+	if tp == nil {
+		tp = tracenoop.NewTracerProvider()
+	}
+	if mp == nil {
+		mp = metricnoop.NewMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	var (
+		t   = &telemetry{tracer: tp.Tracer(instrumentationName)}
+		err error
+	)
+	t.lookups, err = meter.Int64Counter("consul.resolver.lookups",
+		metric.WithDescription("Number of the Consul lookups"))
+	if err != nil {
+		return nil, err
+	}
+	t.errors, err = meter.Int64Counter("consul.resolver.lookup.errors",
+		metric.WithDescription("Number of the failed Consul lookups"))
+	if err != nil {
+		return nil, err
+	}
+	t.fetched, err = meter.Int64Histogram("consul.resolver.endpoints",
+		metric.WithDescription("Number of the endpoints returned by a Consul lookup"))
+	if err != nil {
+		return nil, err
+	}
+	t.updates, err = meter.Int64Counter("consul.resolver.updates",
+		metric.WithDescription("Number of the state updates pushed to the gRPC client connection"))
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// targetAttributes describes the target on spans and metrics
+func targetAttributes(tgt target) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("consul.service", tgt.Service),
+		attribute.String("consul.tag", tgt.Tag),
+		attribute.String("consul.dc", tgt.Dc),
+		attribute.Bool("consul.healthy", tgt.Healthy),
+	}
+}
+
+// lookupSpanName names the lookup span after the Consul API which the servicer calls
+func lookupSpanName(s servicer) string {
+	switch s := s.(type) {
+	case catalogServicer:
+		return "consul.catalog.service"
+	case agentServicer:
+		return "consul.agent.health.service"
+	case *dnsServicer:
+		return "consul.dns.srv"
+	case *fallbackServicer:
+		return lookupSpanName(s.primary)
+	}
+	return "consul.health.service"
+}
+
+// startLookup starts the span for the single Consul call
+func (t *telemetry) startLookup(name string, tgt target, waitIndex uint64) trace.Span {
+	_, span := t.tracer.Start(context.Background(), name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(targetAttributes(tgt)...),
+		trace.WithAttributes(attribute.Int64("consul.wait_index", int64(waitIndex))),
+	)
+	return span
+}
+
+// endLookup records the result of the Consul call on the span and the meters
+func (t *telemetry) endLookup(span trace.Span, tgt target, lastIndex uint64, count int, err error) {
+	attrs := metric.WithAttributes(targetAttributes(tgt)...)
+	t.lookups.Add(context.Background(), 1, attrs)
+	if err != nil {
+		t.errors.Add(context.Background(), 1, attrs)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return
+	}
+	t.fetched.Record(context.Background(), int64(count), attrs)
+	span.SetAttributes(
+		attribute.Int64("consul.last_index", int64(lastIndex)),
+		attribute.Int("consul.endpoints", count),
+	)
+}
+
+// recordUpdate records the cc.UpdateState call as an event of the lookup span and ends it
+func (t *telemetry) recordUpdate(span trace.Span, tgt target, count int, err error) {
+	attrs := []attribute.KeyValue{attribute.Int("addresses", count)}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error", err.Error()))
+	}
+	span.AddEvent("cc.UpdateState", trace.WithAttributes(attrs...))
+	t.updates.Add(context.Background(), 1,
+		metric.WithAttributes(targetAttributes(tgt)...),
+		metric.WithAttributes(attribute.Bool("error", err != nil)))
+	span.End()
+}
@@ -0,0 +1,167 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestTelemetry(t *testing.T) {
+	tests := []struct {
+		name             string
+		errorFromService error
+		wantStatus       codes.Code
+		wantEvent        string
+		wantMetrics      map[string]int64
+	}{
+		{"success", nil, codes.Unset, "cc.UpdateState",
+			map[string]int64{
+				"consul.resolver.lookups":         1,
+				"consul.resolver.lookup.errors":   0,
+				"consul.resolver.updates":         1,
+				"consul.resolver.endpoints.count": 1,
+			},
+		},
+		{"error", errors.New("consul is down"), codes.Error, "exception",
+			map[string]int64{
+				"consul.resolver.lookups":       1,
+				"consul.resolver.lookup.errors": 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var (
+				sr     = tracetest.NewSpanRecorder()
+				ended  = make(chan struct{}, 1)
+				tp     = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr), sdktrace.WithSpanProcessor(endedProcessor{ended}))
+				reader = sdkmetric.NewManualReader()
+				mp     = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+				tgt    = target{Service: "svc", Dc: "dc1", MaxBackoff: time.Hour}
+			)
+			tel, err := newTelemetry(tp, mp)
+			require.NoError(t, err)
+			r := newTestResolvr(t, tgt)
+			r.telemetry = tel
+
+			var calls int32
+			fconsul := &servicerMock{
+				ServiceFunc: func(string, string, bool, *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+					if atomic.AddInt32(&calls, 1) > 1 {
+						<-ctx.Done() // block like a long-polling request
+					}
+					return []*api.ServiceEntry{
+						{Service: &api.AgentService{Address: "127.0.0.1", Port: 1024}},
+					}, &api.QueryMeta{LastIndex: 42}, tt.errorFromService
+				},
+			}
+			updated := make(chan struct{}, 1)
+			fcc := &ClientConnMock{
+				UpdateStateFunc: func(resolver.State) error {
+					updated <- struct{}{}
+					return nil
+				},
+			}
+
+			pipe := make(chan update)
+			go r.watchConsulService(ctx, fconsul, pipe)
+			go r.populateEndpoints(ctx, fcc, pipe)
+			if tt.errorFromService == nil {
+				select {
+				case <-updated:
+				case <-time.After(time.Second):
+					t.Fatal("no state update")
+				}
+			}
+			select {
+			case <-ended:
+			case <-time.After(time.Second):
+				t.Fatal("lookup span isn't ended")
+			}
+
+			spans := sr.Ended()
+			require.Len(t, spans, 1)
+			require.Equal(t, "consul.health.service", spans[0].Name())
+			require.Equal(t, tt.wantStatus, spans[0].Status().Code)
+			require.Contains(t, spans[0].Attributes(), attribute.String("consul.service", "svc"))
+			require.Contains(t, spans[0].Attributes(), attribute.String("consul.dc", "dc1"))
+			require.Contains(t, spans[0].Attributes(), attribute.Int64("consul.wait_index", 0))
+			if tt.errorFromService == nil {
+				require.Contains(t, spans[0].Attributes(), attribute.Int64("consul.last_index", 42))
+				require.Contains(t, spans[0].Attributes(), attribute.Int("consul.endpoints", 1))
+			}
+			require.Len(t, spans[0].Events(), 1)
+			require.Equal(t, tt.wantEvent, spans[0].Events()[0].Name)
+
+			var rm metricdata.ResourceMetrics
+			require.NoError(t, reader.Collect(context.Background(), &rm))
+			got := map[string]int64{}
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					switch d := m.Data.(type) {
+					case metricdata.Sum[int64]:
+						for _, dp := range d.DataPoints {
+							got[m.Name] += dp.Value
+						}
+					case metricdata.Histogram[int64]:
+						for _, dp := range d.DataPoints {
+							got[m.Name+".count"] += int64(dp.Count)
+						}
+					}
+				}
+			}
+			for name, want := range tt.wantMetrics {
+				require.Equal(t, want, got[name], name)
+			}
+		})
+	}
+}
+
+// endedProcessor signals every ended span
+type endedProcessor struct {
+	ended chan<- struct{}
+}
+
+func (endedProcessor) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+func (p endedProcessor) OnEnd(sdktrace.ReadOnlySpan) {
+	select {
+	case p.ended <- struct{}{}:
+	default:
+	}
+}
+func (endedProcessor) Shutdown(context.Context) error   { return nil }
+func (endedProcessor) ForceFlush(context.Context) error { return nil }
+
+func Test_lookupSpanName(t *testing.T) {
+	tests := []struct {
+		name string
+		s    servicer
+		want string
+	}{
+		{"health", &api.Health{}, "consul.health.service"},
+		{"catalog", catalogServicer{}, "consul.catalog.service"},
+		{"agent", agentServicer{}, "consul.agent.health.service"},
+		{"dns", &dnsServicer{}, "consul.dns.srv"},
+		{"fallback", &fallbackServicer{primary: catalogServicer{}, fallback: &dnsServicer{}}, "consul.catalog.service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, lookupSpanName(tt.s))
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package consul
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+)
+
+// ParseError is returned by the Build of the resolver for the malformed connection string.
+// Field is the URL parameter, 'service' for the path or 'url' for the URL itself.
+// Use errors.As to get it from the error of Build. grpc.Dial keeps only its text.
+type ParseError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Malformed URL parameter '%s': %s", e.Field, e.Reason)
+}
+
+func parseErrorf(field, format string, args ...interface{}) *ParseError {
+	return &ParseError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// urlParams are the names of all URL parameters of the target
+var urlParams = func() map[string]bool {
+	params := map[string]bool{}
+	typ := reflect.TypeOf(target{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := typ.Field(i).Tag.Get("form"); len(name) > 0 && name != "-" {
+			params[name] = true
+		}
+	}
+	return params
+}()
+
+// validateStrict rejects unknown URL parameters and contradictory settings in the 'strict' mode.
+// It's called before the defaults are applied; q is the query of the URL.
+func (t *target) validateStrict(q url.Values) error {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !urlParams[k] {
+			return parseErrorf(k, "unknown parameter")
+		}
+	}
+	switch {
+	case t.AllowStale && t.RequireConsistent:
+		return parseErrorf("allow-stale", "can't be combined with require-consistent")
+	case t.Timeout > 0 && t.Wait > 0 && t.Timeout <= t.Wait:
+		return parseErrorf("timeout", "%s must be longer than wait %s for the blocking queries", t.Timeout, t.Wait)
+	case t.Limit < 0:
+		return parseErrorf("limit", "must not be negative")
+	case t.Subset < 0:
+		return parseErrorf("subset", "must not be negative")
+	case t.ZoneMinHealthy < 0:
+		return parseErrorf("zone-min-healthy", "must not be negative")
+	case t.ZoneLimit < 0:
+		return parseErrorf("zone-limit", "must not be negative")
+	case t.RemovalGrace < 0:
+		return parseErrorf("removal-grace", "must not be negative")
+	case q.Has("healthy") && t.Healthy && len(t.Health) > 0:
+		return parseErrorf("healthy", "can't be combined with health")
+	}
+	for _, k := range []string{"zone-key", "zone-min-healthy", "zone-limit"} {
+		if q.Has(k) && len(t.PreferZone) == 0 && !(k == "zone-key" && t.Locality) {
+			return parseErrorf(k, "requires prefer-zone")
+		}
+	}
+	for _, k := range []string{"dns-server", "dns-domain"} {
+		if q.Has(k) && t.Transport != transportDNS && !t.DNSFallback {
+			return parseErrorf(k, "requires transport=dns or dns-fallback")
+		}
+	}
+	if q.Has("client-id") && t.Subset == 0 && t.Order != orderRendezvous {
+		return parseErrorf("client-id", "requires subset or order=rendezvous")
+	}
+	if t.Transport == transportDNS && len(t.Source) > 0 && t.Source != sourceHealth {
+		return parseErrorf("source", "can't be %s with transport=dns", t.Source)
+	}
+	for _, c := range t.Checks {
+		for _, ic := range t.IgnoreChecks {
+			if c == ic {
+				return parseErrorf("ignore-check", "check '%s' is both selected and ignored", c)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestParseURLStrict(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{"valid", "strict=true&healthy=true&wait=10s&timeout=15s&tag=a", ""},
+		{"not-strict", "healty=true&allow-stale=true&require-consistent=true", ""},
+		{"typo", "strict=true&healty=true", "healty"},
+		{"unknown", "strict=true&maxbackoff=5s", "maxbackoff"},
+		{"stale-consistent", "strict=true&allow-stale=true&require-consistent=true", "allow-stale"},
+		{"timeout", "strict=true&wait=30s&timeout=10s", "timeout"},
+		{"negative-limit", "strict=true&limit=-1", "limit"},
+		{"negative-removal-grace", "strict=true&removal-grace=-1s", "removal-grace"},
+		{"healthy-health", "strict=true&healthy=true&health=warning", "healthy"},
+		{"zone-without-prefer", "strict=true&zone-limit=2", "zone-limit"},
+		{"zone-key-locality", "strict=true&zone-key=az&locality=true", ""},
+		{"dns-without-dns", "strict=true&dns-server=127.0.0.1:53", "dns-server"},
+		{"client-id-without-subset", "strict=true&client-id=pod-1", "client-id"},
+		{"client-id-rendezvous", "strict=true&client-id=pod-1&order=rendezvous", ""},
+		{"catalog-dns", "strict=true&source=catalog&transport=dns", "source"},
+		{"check-ignored", "strict=true&check=grpc&ignore-check=grpc", "ignore-check"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseURL("consul://127.0.0.1:8500/svc?"+tt.query, target{})
+			if len(tt.field) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var perr *ParseError
+			require.True(t, errors.As(err, &perr), err)
+			require.Equal(t, tt.field, perr.Field)
+		})
+	}
+
+	_, err := parseURL("consul://127.0.0.1:8500/svc?healty=true", target{Strict: true})
+	require.Error(t, err, "strict by default")
+}
+
+func TestParseErrorFields(t *testing.T) {
+	tests := []struct {
+		input string
+		field string
+	}{
+		{"consul://127.0.0.1:8500", "url"},
+		{"consul://127.0.0.1:8500/svc?insecure=BAD", "insecure"},
+		{"consul://127.0.0.1:8500/svc?health=sick", "health"},
+		{"consul://127.0.0.1:8500/a,,b", "service"},
+		{"consul://127.0.0.1:8500/svc?split=a", "split"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := parseURL(tt.input, target{})
+			var perr *ParseError
+			require.True(t, errors.As(err, &perr), err)
+			require.Equal(t, tt.field, perr.Field)
+		})
+	}
+}
+
+func TestParseURLStrictDefaults(t *testing.T) {
+	_, err := parseURL("consul://127.0.0.1:8500/svc?strict=true&health=warning", target{Healthy: true})
+	require.NoError(t, err, "healthy of the builder defaults isn't in the URL")
+}
+
+func TestBuilderParseError(t *testing.T) {
+	_, err := NewBuilder(WithStrict(true)).Build(resolver.Target{URL: url.URL{
+		Scheme:   schemeName,
+		Host:     "127.0.0.1:8500",
+		Path:     "/svc",
+		RawQuery: "healty=true",
+	}}, &ClientConnMock{}, resolver.BuildOptions{})
+	var perr *ParseError
+	require.True(t, errors.As(err, &perr), err)
+	require.Equal(t, &ParseError{Field: "healty", Reason: "unknown parameter"}, perr)
+}
+
+func TestDialParseError(t *testing.T) {
+	_, err := grpc.Dial("consul://127.0.0.1:8500/svc?strict=true&healty=true",
+		grpc.WithResolvers(NewBuilder()),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.ErrorContains(t, err, "Malformed URL parameter 'healty': unknown parameter")
+	var perr *ParseError
+	require.False(t, errors.As(err, &perr), "grpc.Dial keeps only the text of the error")
+}
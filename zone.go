@@ -0,0 +1,83 @@
+package consul
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// agentZone is the 'prefer-zone' value for the zone of the local Consul agent
+const agentZone = "_agent"
+
+type agentSelfer interface {
+	Self() (map[string]map[string]interface{}, error)
+}
+
+// missingSetting is the error of the setting which the local agent doesn't have.
+// Unlike the unavailable agent it's a permanent setup issue, so it isn't retried.
+type missingSetting string
+
+func (e missingSetting) Error() string { return string(e) }
+
+// isMissingSetting is true when the agent is available but doesn't have the setting
+func isMissingSetting(err error) bool {
+	_, ok := errors.Cause(err).(missingSetting)
+	return ok
+}
+
+// localZone returns the zone of the local agent from its node meta
+func localZone(a agentSelfer, key string) (string, error) {
+	self, err := a.Self()
+	if err != nil {
+		return "", errors.Wrap(err, "Couldn't fetch the local agent")
+	}
+	zone, _ := self["Meta"][key].(string)
+	if len(zone) == 0 {
+		return "", missingSetting(fmt.Sprintf("Local agent has no '%s' node meta", key))
+	}
+	return zone, nil
+}
+
+// localDatacenter returns the datacenter of the local agent
+func localDatacenter(a agentSelfer) (string, error) {
+	self, err := a.Self()
+	if err != nil {
+		return "", errors.Wrap(err, "Couldn't fetch the local agent")
+	}
+	dc, _ := self["Config"]["Datacenter"].(string)
+	if len(dc) == 0 {
+		return "", missingSetting("Local agent has no datacenter")
+	}
+	return dc, nil
+}
+
+// preferZone returns endpoints in the zone while it has at least minHealthy passing endpoints
+// and falls back to all zones otherwise. Endpoints of the zone go first.
+// With the positive perZone every zone contributes at most perZone endpoints.
+func preferZone(ee []endpoint, zone, key string, minHealthy, perZone int) []endpoint {
+	var (
+		local, other []endpoint
+		healthy      int
+		perZoneCount = map[string]int{}
+	)
+	for _, e := range ee {
+		z := e.NodeMeta[key]
+		if perZone > 0 && perZoneCount[z] >= perZone {
+			continue
+		}
+		perZoneCount[z]++
+		if z != zone {
+			other = append(other, e)
+			continue
+		}
+		local = append(local, e)
+		if e.Status == api.HealthPassing {
+			healthy++
+		}
+	}
+	if healthy >= minHealthy && len(local) > 0 {
+		return local
+	}
+	return append(local, other...)
+}
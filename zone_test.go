@@ -0,0 +1,168 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAgent struct {
+	self map[string]map[string]interface{}
+	err  error
+}
+
+func (f fakeAgent) Self() (map[string]map[string]interface{}, error) {
+	return f.self, f.err
+}
+
+func Test_localZone(t *testing.T) {
+	zone, err := localZone(fakeAgent{self: map[string]map[string]interface{}{"Meta": {"zone": "a"}}}, "zone")
+	require.NoError(t, err)
+	require.Equal(t, "a", zone)
+
+	_, err = localZone(fakeAgent{self: map[string]map[string]interface{}{"Meta": {}}}, "zone")
+	require.Error(t, err)
+
+	_, err = localZone(fakeAgent{err: errors.New("agent is down")}, "zone")
+	require.Error(t, err)
+}
+
+func Test_preferZone(t *testing.T) {
+	ep := func(addr, zone, status string) endpoint {
+		return endpoint{Addr: addr, NodeMeta: map[string]string{"zone": zone}, Status: status}
+	}
+	var (
+		a1 = ep("a1", "a", api.HealthPassing)
+		a2 = ep("a2", "a", api.HealthCritical)
+		b1 = ep("b1", "b", api.HealthPassing)
+		b2 = ep("b2", "b", api.HealthPassing)
+	)
+	tests := []struct {
+		name       string
+		input      []endpoint
+		minHealthy int
+		perZone    int
+		want       []endpoint
+	}{
+		{"local", []endpoint{b1, a1, a2}, 1, 0, []endpoint{a1, a2}},
+		{"fallback", []endpoint{b1, a1, a2}, 2, 0, []endpoint{a1, a2, b1}},
+		{"no-local", []endpoint{b1, b2}, 1, 0, []endpoint{b1, b2}},
+		{"local-unhealthy", []endpoint{b1, a2}, 1, 0, []endpoint{a2, b1}},
+		{"per-zone-limit", []endpoint{b1, b2, a1, a2}, 3, 1, []endpoint{a1, b1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, preferZone(tt.input, "a", "zone", tt.minHealthy, tt.perZone))
+		})
+	}
+}
+
+// flakyAgent fails the first calls of Self
+type flakyAgent struct {
+	failures int32
+	calls    int32
+	self     map[string]map[string]interface{}
+}
+
+func (f *flakyAgent) Self() (map[string]map[string]interface{}, error) {
+	if atomic.AddInt32(&f.calls, 1) <= f.failures {
+		return nil, errors.New("agent is down")
+	}
+	return f.self, nil
+}
+
+// newBlockingServicer returns servicer with two endpoints in zones 'a' and 'b' which never change.
+// Queries with an index block for their wait time like Consul does.
+func newBlockingServicer(ctx context.Context) *servicerMock {
+	return &servicerMock{
+		ServiceFunc: func(_, _ string, _ bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+			if q.WaitIndex > 0 {
+				wait := make(<-chan time.Time)
+				if q.WaitTime > 0 {
+					wait = time.After(q.WaitTime)
+				}
+				select {
+				case <-wait:
+				case <-ctx.Done():
+				}
+			}
+			return []*api.ServiceEntry{
+				{Node: &api.Node{Meta: map[string]string{"zone": "a"}}, Service: &api.AgentService{Address: "10.0.0.1", Port: 80}},
+				{Node: &api.Node{Meta: map[string]string{"zone": "b"}}, Service: &api.AgentService{Address: "10.0.0.2", Port: 80}},
+			}, &api.QueryMeta{LastIndex: 1}, nil
+		},
+	}
+}
+
+func TestWatchAgentZoneRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fconsul := newBlockingServicer(ctx)
+	tgt, err := parseURL("consul://127.0.0.1:8500/svc?prefer-zone=&max-backoff=20ms", target{})
+	require.NoError(t, err)
+	require.Equal(t, agentZone, tgt.PreferZone, "empty prefer-zone is the agent zone")
+	r := newTestResolvr(t, tgt)
+	agent := &flakyAgent{failures: 2, self: map[string]map[string]interface{}{"Meta": {"zone": "a"}}}
+	r.agent = agent
+	out := make(chan update)
+	go r.watchService(ctx, fconsul, tgt, out)
+
+	next := func() update {
+		select {
+		case u := <-out:
+			return u
+		case <-time.After(time.Second):
+			t.Fatal("no update")
+		}
+		return update{}
+	}
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, addrsOf(next().endpoints), "no preference while the agent is down")
+	require.Equal(t, []string{"10.0.0.1:80"}, addrsOf(next().endpoints), "unchanged endpoints aren't pushed until the zone is known")
+	require.EqualValues(t, 3, atomic.LoadInt32(&agent.calls))
+
+	calls := fconsul.ServiceCalls()
+	require.Zero(t, calls[0].QueryOptions.WaitIndex)
+	i := 1
+	for ; calls[i].QueryOptions.WaitIndex > 0; i++ {
+		require.Positive(t, calls[i].QueryOptions.WaitTime, "the wait is cut for the retry of the agent")
+	}
+	require.Equal(t, 3, i, "queries block between the retries")
+	for _, c := range calls[i+1:] {
+		require.Equal(t, uint64(1), c.QueryOptions.WaitIndex, "only the query with the resolved zone doesn't block")
+		require.Zero(t, c.QueryOptions.WaitTime)
+	}
+}
+
+func TestWatchAgentZoneMissing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fconsul := newBlockingServicer(ctx)
+	tgt, err := parseURL("consul://127.0.0.1:8500/svc?prefer-zone=_agent&max-backoff=20ms", target{})
+	require.NoError(t, err)
+	r := newTestResolvr(t, tgt)
+	agent := &flakyAgent{self: map[string]map[string]interface{}{"Meta": {}}}
+	r.agent = agent
+	out := make(chan update)
+	go r.watchService(ctx, fconsul, tgt, out)
+
+	select {
+	case u := <-out:
+		require.Len(t, u.endpoints, 2, "preference is disabled")
+	case <-time.After(time.Second):
+		t.Fatal("no update")
+	}
+	require.Eventually(t, func() bool { return len(fconsul.ServiceCalls()) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	calls := fconsul.ServiceCalls()
+	require.Len(t, calls, 2, "the query blocks")
+	require.Equal(t, uint64(1), calls[1].QueryOptions.WaitIndex)
+	require.Zero(t, calls[1].QueryOptions.WaitTime)
+	require.EqualValues(t, 1, atomic.LoadInt32(&agent.calls), "missing zone meta isn't retried")
+}